@@ -0,0 +1,113 @@
+package variably
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// timestampHeader carries the Unix time at which a request was signed
+	timestampHeader = "X-Variably-Timestamp"
+	// signatureHeader carries the hex HMAC-SHA256 signature of a request
+	signatureHeader = "X-Variably-Signature"
+)
+
+// signRequest signs a request with HMAC-SHA256 over its method, path and
+// query, timestamp and the SHA-256 of its body as sent, one per line. The
+// timestamp lets the API reject replayed requests.
+func signRequest(req *http.Request, body []byte, secret string, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// setRequestHeaders sets the authentication and client headers shared by all
+// requests to the API, signing the request if enabled. body is the payload as
+// sent on the wire.
+func (c *HTTPClient) setRequestHeaders(req *http.Request, apiKey string, body []byte) {
+	req.Header.Set("X-API-Key", apiKey)
+	req.Header.Set("User-Agent", "Variably-Go-SDK/1.0.0")
+	if c.signing.Enabled {
+		signRequest(req, body, c.signing.Secret, time.Now())
+	}
+}
+
+// RotateAPIKey switches requests to a new API key without recreating the
+// client. The previous key is kept as a fallback for requests the API rejects
+// as unauthorized, in case the new key has not propagated yet, and is retired
+// once a request with the new key succeeds.
+func (c *HTTPClient) RotateAPIKey(newKey string) error {
+	if newKey == "" {
+		return NewValidationError("API key must not be empty", "api_key", nil)
+	}
+
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	if newKey == c.apiKey {
+		return nil
+	}
+	c.previousAPIKey = c.apiKey
+	c.apiKey = newKey
+
+	c.logger.Info("API key rotated, previous key kept until the new key is accepted")
+	return nil
+}
+
+// keys returns the current API key and the previous key, if still in use
+func (c *HTTPClient) keys() (string, string) {
+	c.keyMutex.RLock()
+	defer c.keyMutex.RUnlock()
+	return c.apiKey, c.previousAPIKey
+}
+
+// retirePreviousKey drops the previous key once the given current key has been accepted
+func (c *HTTPClient) retirePreviousKey(acceptedKey string) {
+	c.keyMutex.Lock()
+	defer c.keyMutex.Unlock()
+
+	if c.apiKey == acceptedKey && c.previousAPIKey != "" {
+		c.previousAPIKey = ""
+		c.logger.Info("New API key accepted, previous key retired")
+	}
+}
+
+// doRequestWithKeys sends the request with the current API key, falling back
+// to the previous key while a rotation is in progress
+func (c *HTTPClient) doRequestWithKeys(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	apiKey, previousKey := c.keys()
+
+	err := c.doRequestWithFailover(ctx, apiKey, method, path, body, result)
+	if err == nil {
+		c.retirePreviousKey(apiKey)
+		return nil
+	}
+	if previousKey == "" || !isUnauthorized(err) {
+		return err
+	}
+
+	c.logger.Warn("New API key rejected, retrying with previous key", "path", path)
+	return c.doRequestWithFailover(ctx, previousKey, method, path, body, result)
+}
+
+// isUnauthorized reports whether the API rejected a request's credentials
+func isUnauthorized(err error) bool {
+	switch e := err.(type) {
+	case *AuthenticationError:
+		return true
+	case *NetworkError:
+		return e.StatusCode == http.StatusUnauthorized
+	default:
+		return false
+	}
+}
@@ -0,0 +1,120 @@
+package variably
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flagBatcher merges single-flag evaluations for the same user that arrive
+// within a short window into one batch API call
+type flagBatcher struct {
+	window       time.Duration
+	maxBatchSize int
+	evaluate     func(ctx context.Context, flagKeys []string, userContext UserContext) map[string]FlagResult
+
+	mutex   sync.Mutex
+	pending map[string]*flagBatch // by user fingerprint
+}
+
+// flagBatch collects flag keys for one user until it is flushed
+type flagBatch struct {
+	key         string
+	userContext UserContext
+	flagKeys    []string
+	requested   map[string]bool
+	timer       *time.Timer
+
+	// Latest deadline of the callers waiting, unless one of them has none
+	deadline  time.Time
+	unbounded bool
+
+	done    chan struct{}
+	results map[string]FlagResult
+}
+
+// newFlagBatcher creates a batcher that evaluates batches with evaluate
+func newFlagBatcher(config BatchingConfig, evaluate func(ctx context.Context, flagKeys []string, userContext UserContext) map[string]FlagResult) *flagBatcher {
+	return &flagBatcher{
+		window:       config.Window,
+		maxBatchSize: config.MaxBatchSize,
+		evaluate:     evaluate,
+		pending:      make(map[string]*flagBatch),
+	}
+}
+
+// Evaluate adds the flag to the user's pending batch and waits for the batch
+// result. A batch is sent when its window elapses or it reaches the maximum
+// size. The batch request runs until the latest of its callers' deadlines, so
+// that every caller's budget is honoured, while each caller still returns as
+// soon as its own context is done.
+func (b *flagBatcher) Evaluate(ctx context.Context, flagKey string, userContext UserContext) FlagResult {
+	key := fingerprintUser(userContext, nil)
+
+	b.mutex.Lock()
+	batch, exists := b.pending[key]
+	if !exists {
+		batch = &flagBatch{
+			key:         key,
+			userContext: userContext,
+			requested:   make(map[string]bool),
+			done:        make(chan struct{}),
+		}
+		b.pending[key] = batch
+		batch.timer = time.AfterFunc(b.window, func() { b.flush(batch) })
+	}
+	if deadline, ok := ctx.Deadline(); !ok {
+		batch.unbounded = true
+	} else if deadline.After(batch.deadline) {
+		batch.deadline = deadline
+	}
+	if !batch.requested[flagKey] {
+		batch.requested[flagKey] = true
+		batch.flagKeys = append(batch.flagKeys, flagKey)
+	}
+	full := len(batch.flagKeys) >= b.maxBatchSize
+	if full {
+		// Later evaluations start a new batch
+		delete(b.pending, key)
+	}
+	b.mutex.Unlock()
+
+	if full && batch.timer.Stop() {
+		go b.flush(batch)
+	}
+
+	select {
+	case <-batch.done:
+		return batch.results[flagKey]
+	case <-ctx.Done():
+		return FlagResult{
+			Key:         flagKey,
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeNetwork,
+			Error:       ctx.Err(),
+			EvaluatedAt: time.Now(),
+		}
+	}
+}
+
+// flush sends a batch and releases its waiters
+func (b *flagBatcher) flush(batch *flagBatch) {
+	b.mutex.Lock()
+	if b.pending[batch.key] == batch {
+		delete(b.pending, batch.key)
+	}
+	flagKeys := batch.flagKeys
+	deadline, unbounded := batch.deadline, batch.unbounded
+	b.mutex.Unlock()
+
+	// Without a deadline from every caller, TimeoutConfig.Batch bounds the request
+	ctx := context.Background()
+	if !unbounded {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	batch.results = b.evaluate(ctx, flagKeys, batch.userContext)
+	close(batch.done)
+}
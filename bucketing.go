@@ -0,0 +1,101 @@
+package variably
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+// BucketCount is the number of rollout buckets. Buckets are basis points, so a
+// bucket of 2550 corresponds to 25.50% of users.
+const BucketCount = 10000
+
+// Common bucketing attributes. Any key in UserContext.Attributes may also be used.
+const (
+	BucketByUserID    = "user_id"
+	BucketBySessionID = "session_id"
+)
+
+// Bucket deterministically maps a value into [0, BucketCount). The bucket is
+// MurmurHash3 (x86, 32-bit, seed 0) of "salt:key:value", modulo BucketCount.
+// Other Variably SDKs use the same scheme, so buckets agree across languages.
+func Bucket(salt, key, value string) int {
+	return int(Murmur3([]byte(salt+":"+key+":"+value), 0) % BucketCount)
+}
+
+// BucketUser buckets a user on the given attribute, defaulting to the user ID.
+// It returns false if the user has no value for the attribute. Non-string
+// attribute values are formatted with fmt.Sprint before hashing.
+func BucketUser(userContext UserContext, salt, key, attribute string) (int, bool) {
+	value, ok := userAttribute(userContext, bucketAttribute(attribute))
+	if !ok {
+		return 0, false
+	}
+
+	return Bucket(salt, key, fmt.Sprint(value)), true
+}
+
+// bucketAttribute returns the attribute to bucket on, defaulting to the user ID
+func bucketAttribute(attribute string) string {
+	if attribute == "" {
+		return BucketByUserID
+	}
+	return attribute
+}
+
+// bucketPercentage converts a bucket into a percentage in [0, 100)
+func bucketPercentage(bucket int) float64 {
+	return float64(bucket) * 100 / BucketCount
+}
+
+// Murmur3 computes the 32-bit x86 variant of MurmurHash3
+func Murmur3(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	length := len(data)
+
+	// Body
+	blocks := length / 4
+	for i := 0; i < blocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	// Tail
+	tail := data[blocks*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	// Finalization
+	h ^= uint32(length)
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+
+	return h
+}
@@ -0,0 +1,81 @@
+package variably
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	// Reference values from the canonical SMHasher implementation
+	tests := []struct {
+		input string
+		seed  uint32
+		want  uint32
+	}{
+		{"", 0, 0},
+		{"", 1, 0x514e28b7},
+		{"\xff\xff\xff\xff", 0, 0x76293b50},
+		{"hello", 0, 0x248bfa47},
+		{"The quick brown fox jumps over the lazy dog", 0, 0x2e4ff723},
+	}
+
+	for _, tt := range tests {
+		if got := Murmur3([]byte(tt.input), tt.seed); got != tt.want {
+			t.Errorf("Murmur3(%q, %d) = %#x, want %#x", tt.input, tt.seed, got, tt.want)
+		}
+	}
+}
+
+func TestBucketVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/bucketing_vectors.json")
+	if err != nil {
+		t.Fatalf("Failed to read test vectors: %v", err)
+	}
+
+	var file struct {
+		Vectors []struct {
+			Salt   string `json:"salt"`
+			Key    string `json:"key"`
+			Value  string `json:"value"`
+			Hash   uint32 `json:"hash"`
+			Bucket int    `json:"bucket"`
+		} `json:"vectors"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Failed to parse test vectors: %v", err)
+	}
+
+	for _, v := range file.Vectors {
+		if got := Murmur3([]byte(v.Salt+":"+v.Key+":"+v.Value), 0); got != v.Hash {
+			t.Errorf("hash(%q, %q, %q) = %d, want %d", v.Salt, v.Key, v.Value, got, v.Hash)
+		}
+		if got := Bucket(v.Salt, v.Key, v.Value); got != v.Bucket {
+			t.Errorf("Bucket(%q, %q, %q) = %d, want %d", v.Salt, v.Key, v.Value, got, v.Bucket)
+		}
+	}
+}
+
+func TestBucketUser(t *testing.T) {
+	user := UserContext{
+		UserID:     "user_123",
+		SessionID:  "session_abc",
+		Attributes: map[string]interface{}{"account_id": float64(42)},
+	}
+
+	if got, ok := BucketUser(user, "", "new_checkout", ""); !ok || got != Bucket("", "new_checkout", "user_123") {
+		t.Errorf("Expected default bucketing on user ID, got %d", got)
+	}
+
+	if got, ok := BucketUser(user, "s", "k", BucketBySessionID); !ok || got != Bucket("s", "k", "session_abc") {
+		t.Errorf("Expected bucketing on session ID, got %d", got)
+	}
+
+	if got, ok := BucketUser(user, "s", "k", "account_id"); !ok || got != Bucket("s", "k", "42") {
+		t.Errorf("Expected bucketing on custom attribute, got %d", got)
+	}
+
+	if _, ok := BucketUser(user, "s", "k", "missing"); ok {
+		t.Error("Expected missing attribute to be unbucketable")
+	}
+}
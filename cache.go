@@ -4,6 +4,7 @@ import (
 	"container/list"
 	"encoding/json"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,6 +16,9 @@ type MemoryCache struct {
 	lruList    *list.List
 	mutex      sync.RWMutex
 	defaultTTL time.Duration
+
+	// How long expired items are kept for GetStale; zero drops them on expiry
+	staleTTL time.Duration
 }
 
 type cacheItem struct {
@@ -47,7 +51,10 @@ func (c *MemoryCache) Get(key string) (FlagResult, bool) {
 	// Check if item has expired
 	if time.Now().After(item.expiration) {
 		// Item expired, remove it (but don't delete while holding read lock)
-		go c.Delete(key)
+		// unless it is kept around to be served stale
+		if c.staleTTL == 0 {
+			go c.Delete(key)
+		}
 		return FlagResult{}, false
 	}
 
@@ -56,6 +63,19 @@ func (c *MemoryCache) Get(key string) (FlagResult, bool) {
 	return item.value, true
 }
 
+// GetStale retrieves a value even if it has expired, as long as it is
+// within the stale window
+func (c *MemoryCache) GetStale(key string) (FlagResult, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	item, exists := c.items[key]
+	if !exists || time.Now().After(item.expiration.Add(c.staleTTL)) {
+		return FlagResult{}, false
+	}
+	return item.value, true
+}
+
 // Set stores a value in the cache
 func (c *MemoryCache) Set(key string, result FlagResult, ttl time.Duration) {
 	c.mutex.Lock()
@@ -142,7 +162,8 @@ func (c *MemoryCache) evictIfNeeded() {
 	}
 }
 
-// CleanupExpired removes all expired items from the cache
+// CleanupExpired removes all expired items from the cache, keeping those
+// still within the stale window
 func (c *MemoryCache) CleanupExpired() {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -151,7 +172,7 @@ func (c *MemoryCache) CleanupExpired() {
 	var expiredKeys []string
 
 	for key, item := range c.items {
-		if now.After(item.expiration) {
+		if now.After(item.expiration.Add(c.staleTTL)) {
 			expiredKeys = append(expiredKeys, key)
 		}
 	}
@@ -198,6 +219,11 @@ func (c *PersistentCache) Get(key string) (FlagResult, bool) {
 	return c.memoryCache.Get(key)
 }
 
+// GetStale retrieves a value even if it has expired, as long as it is within the stale window
+func (c *PersistentCache) GetStale(key string) (FlagResult, bool) {
+	return c.memoryCache.GetStale(key)
+}
+
 // Set stores a value in the cache and persists it
 func (c *PersistentCache) Set(key string, result FlagResult, ttl time.Duration) {
 	c.memoryCache.Set(key, result, ttl)
@@ -292,6 +318,15 @@ type CacheManager struct {
 	cache  Cache
 	config CacheConfig
 	logger Logger
+
+	// Downloaded ruleset for local evaluation
+	ruleset      *Ruleset
+	rulesetMutex sync.RWMutex
+
+	// Segment definitions, from the ruleset or downloaded on their own
+	segments          map[string]*segment
+	segmentsUpdatedAt time.Time
+	segmentsMutex     sync.RWMutex
 }
 
 // NewCacheManager creates a new cache manager with the specified configuration
@@ -299,16 +334,25 @@ func NewCacheManager(config CacheConfig, logger Logger) *CacheManager {
 	var cache Cache
 
 	if config.EnablePersistence && config.PersistencePath != "" {
-		cache = NewPersistentCache(config.MaxSize, config.TTL, config.PersistencePath)
+		persistentCache := NewPersistentCache(config.MaxSize, config.TTL, config.PersistencePath)
+		persistentCache.memoryCache.staleTTL = config.StaleTTL
+		cache = persistentCache
 	} else {
-		cache = NewMemoryCache(config.MaxSize, config.TTL)
+		memoryCache := NewMemoryCache(config.MaxSize, config.TTL)
+		memoryCache.staleTTL = config.StaleTTL
+		cache = memoryCache
 	}
 
-	return &CacheManager{
+	cm := &CacheManager{
 		cache:  cache,
 		config: config,
 		logger: logger,
 	}
+
+	// Restore the last persisted ruleset so local evaluation works offline
+	cm.loadRuleset()
+
+	return cm
 }
 
 // Get retrieves a value from the cache
@@ -316,6 +360,19 @@ func (cm *CacheManager) Get(key string) (FlagResult, bool) {
 	return cm.cache.Get(key)
 }
 
+// GetStale retrieves a value that may have expired, for use when fresh values
+// cannot be fetched. Only the built-in caches keep expired values.
+func (cm *CacheManager) GetStale(key string) (FlagResult, bool) {
+	switch cache := cm.cache.(type) {
+	case *MemoryCache:
+		return cache.GetStale(key)
+	case *PersistentCache:
+		return cache.GetStale(key)
+	default:
+		return FlagResult{}, false
+	}
+}
+
 // Set stores a value in the cache
 func (cm *CacheManager) Set(key string, result FlagResult, ttl time.Duration) {
 	if ttl == 0 {
@@ -329,6 +386,18 @@ func (cm *CacheManager) Delete(key string) {
 	cm.cache.Delete(key)
 }
 
+// DeletePrefix removes every value whose key starts with prefix
+func (cm *CacheManager) DeletePrefix(prefix string) int {
+	deleted := 0
+	for _, key := range cm.cache.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			cm.cache.Delete(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
 // Clear removes all values from the cache
 func (cm *CacheManager) Clear() {
 	cm.cache.Clear()
@@ -345,6 +414,136 @@ func (cm *CacheManager) Keys() []string {
 	return cm.cache.Keys()
 }
 
+// GetRuleset returns the current ruleset, or nil if none has been loaded
+func (cm *CacheManager) GetRuleset() *Ruleset {
+	cm.rulesetMutex.RLock()
+	defer cm.rulesetMutex.RUnlock()
+	return cm.ruleset
+}
+
+// SetRuleset replaces the current ruleset and persists it if persistence is enabled
+func (cm *CacheManager) SetRuleset(ruleset *Ruleset) {
+	if ruleset != nil {
+		ruleset.normalizeKeys()
+	}
+
+	cm.rulesetMutex.Lock()
+	cm.ruleset = ruleset
+	cm.rulesetMutex.Unlock()
+
+	if ruleset != nil {
+		cm.SetSegments(ruleset.Segments)
+	}
+	cm.saveRuleset(ruleset)
+}
+
+// GetFlagDefinition returns the ruleset's definition of a flag
+func (cm *CacheManager) GetFlagDefinition(key string) (FlagDefinition, bool) {
+	ruleset := cm.GetRuleset()
+	if ruleset == nil {
+		return FlagDefinition{}, false
+	}
+	def, ok := ruleset.Flags[key]
+	return def, ok
+}
+
+// GetSegment returns the segment with the given key
+func (cm *CacheManager) GetSegment(key string) (*segment, bool) {
+	cm.segmentsMutex.RLock()
+	defer cm.segmentsMutex.RUnlock()
+	s, ok := cm.segments[key]
+	return s, ok
+}
+
+// SetSegments replaces all cached segment definitions
+func (cm *CacheManager) SetSegments(defs map[string]SegmentDefinition) {
+	segments := indexSegments(defs)
+
+	cm.segmentsMutex.Lock()
+	cm.segments = segments
+	cm.segmentsUpdatedAt = time.Now()
+	cm.segmentsMutex.Unlock()
+}
+
+// ExpireSegments marks the cached segments as expired so they are downloaded again on next use
+func (cm *CacheManager) ExpireSegments() {
+	cm.segmentsMutex.Lock()
+	cm.segmentsUpdatedAt = time.Time{}
+	cm.segmentsMutex.Unlock()
+}
+
+// HasSegments reports whether segment definitions have ever been loaded
+func (cm *CacheManager) HasSegments() bool {
+	cm.segmentsMutex.RLock()
+	defer cm.segmentsMutex.RUnlock()
+	return cm.segments != nil
+}
+
+// SegmentsExpired reports whether segments have never been loaded or are older than the cache TTL
+func (cm *CacheManager) SegmentsExpired() bool {
+	cm.segmentsMutex.RLock()
+	defer cm.segmentsMutex.RUnlock()
+	return cm.segmentsUpdatedAt.IsZero() || time.Since(cm.segmentsUpdatedAt) > cm.config.TTL
+}
+
+// rulesetPath returns the file used to persist the ruleset, or "" if persistence is disabled
+func (cm *CacheManager) rulesetPath() string {
+	if !cm.config.EnablePersistence || cm.config.PersistencePath == "" {
+		return ""
+	}
+	return cm.config.PersistencePath + ".ruleset"
+}
+
+// loadRuleset loads a previously persisted ruleset
+func (cm *CacheManager) loadRuleset() {
+	path := cm.rulesetPath()
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// File doesn't exist or can't be read, wait for the first download
+		return
+	}
+
+	var ruleset Ruleset
+	if err := json.Unmarshal(data, &ruleset); err != nil {
+		cm.logger.Warn("Ignoring invalid persisted ruleset", "path", path, "error", err)
+		return
+	}
+	ruleset.normalizeKeys()
+
+	cm.rulesetMutex.Lock()
+	cm.ruleset = &ruleset
+	cm.rulesetMutex.Unlock()
+
+	cm.SetSegments(ruleset.Segments)
+}
+
+// saveRuleset persists the ruleset to disk
+func (cm *CacheManager) saveRuleset(ruleset *Ruleset) {
+	path := cm.rulesetPath()
+	if path == "" || ruleset == nil {
+		return
+	}
+
+	data, err := json.Marshal(ruleset)
+	if err != nil {
+		cm.logger.Warn("Failed to marshal ruleset", "error", err)
+		return
+	}
+
+	// Write to temporary file first, then rename (atomic operation)
+	tempFile := path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		cm.logger.Warn("Failed to persist ruleset", "path", path, "error", err)
+		return
+	}
+
+	os.Rename(tempFile, path)
+}
+
 // StartCleanup starts a background goroutine to clean up expired cache entries
 func (cm *CacheManager) StartCleanup(stopCh <-chan struct{}) {
 	ticker := time.NewTicker(time.Minute) // Clean up every minute
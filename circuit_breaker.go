@@ -0,0 +1,135 @@
+package variably
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed lets requests through and counts consecutive failures
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects requests immediately until the reset timeout elapses
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a limited number of trial requests through to probe recovery
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// circuitBreaker guards a single API path on one endpoint. After FailureThreshold
+// consecutive failures it opens and rejects requests; once ResetTimeout has
+// passed it lets trial requests through, closing again if they succeed.
+type circuitBreaker struct {
+	endpoint string
+	config   CircuitBreakerConfig
+	mutex    sync.Mutex
+
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	inFlight int // trial requests in flight while half-open
+
+	onStateChange func(endpoint string, from, to CircuitState)
+}
+
+// newCircuitBreaker creates a closed circuit breaker for an endpoint
+func newCircuitBreaker(endpoint string, config CircuitBreakerConfig, onStateChange func(endpoint string, from, to CircuitState)) *circuitBreaker {
+	return &circuitBreaker{
+		endpoint:      endpoint,
+		config:        config,
+		state:         CircuitClosed,
+		onStateChange: onStateChange,
+	}
+}
+
+// allow reports whether a request may proceed. Every allowed request must be
+// followed by a call to done.
+func (cb *circuitBreaker) allow() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.state == CircuitOpen {
+		if time.Since(cb.openedAt) < cb.config.ResetTimeout {
+			return NewCircuitOpenError(cb.endpoint, cb.openedAt.Add(cb.config.ResetTimeout))
+		}
+		cb.transition(CircuitHalfOpen)
+	}
+
+	if cb.state == CircuitHalfOpen {
+		if cb.inFlight >= cb.config.HalfOpenMaxRequests {
+			return NewCircuitOpenError(cb.endpoint, time.Time{})
+		}
+		cb.inFlight++
+	}
+
+	return nil
+}
+
+// done records the outcome of an allowed request
+func (cb *circuitBreaker) done(ctx context.Context, err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	halfOpen := cb.state == CircuitHalfOpen
+	if halfOpen {
+		cb.inFlight--
+	}
+
+	// Requests the caller cancelled or ran out of time for say nothing about
+	// the endpoint's health. Attempts that exceed Timeout still count.
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+
+	if !isEndpointFailure(err) {
+		cb.failures = 0
+		if halfOpen {
+			cb.transition(CircuitClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	if halfOpen || cb.failures >= cb.config.FailureThreshold {
+		cb.openedAt = time.Now()
+		if cb.state != CircuitOpen {
+			cb.transition(CircuitOpen)
+		}
+	}
+}
+
+// State returns the breaker's current state
+func (cb *circuitBreaker) State() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
+
+// transition changes state and reports the change. The caller must hold the mutex.
+func (cb *circuitBreaker) transition(to CircuitState) {
+	from := cb.state
+	cb.state = to
+	if to != CircuitOpen {
+		cb.failures = 0
+	}
+	if cb.onStateChange != nil {
+		cb.onStateChange(cb.endpoint, from, to)
+	}
+}
+
+// isEndpointFailure reports whether an error indicates the endpoint is unhealthy.
+// Client errors such as bad requests or missing flags do not count.
+func isEndpointFailure(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case *NetworkError:
+		return e.StatusCode == 0 || e.StatusCode >= 500
+	case *TimeoutError:
+		return true
+	default:
+		return false
+	}
+}
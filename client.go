@@ -2,6 +2,7 @@ package variably
 
 import (
 	"context"
+	"sync"
 	"time"
 )
 
@@ -18,22 +19,33 @@ type Client interface {
 	// Feature Gate Operations
 	EvaluateGate(ctx context.Context, gateKey string, userContext UserContext) bool
 
+	// Experiments
+	GetExperimentAssignment(ctx context.Context, experimentKey string, userContext UserContext) (ExperimentAssignment, error)
+
+	// Segments
+	IsInSegment(ctx context.Context, segmentKey string, userContext UserContext) bool
+
 	// Batch Operations
 	EvaluateFlags(ctx context.Context, flagKeys []string, userContext UserContext) map[string]FlagResult
 	EvaluateGates(ctx context.Context, gateKeys []string, userContext UserContext) map[string]bool
+	AllFlags(ctx context.Context, userContext UserContext) (FlagsState, error)
 
 	// Event Tracking
 	Track(ctx context.Context, event Event) error
 	TrackBatch(ctx context.Context, events []Event) error
 
 	// Real-time Updates
-	Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) error
+	Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) (*Subscription, error)
 	Unsubscribe(flagKeys []string) error
+	Watch(ctx context.Context, flagKey string, userContext UserContext) <-chan FlagResult
 
 	// Cache Management
 	RefreshCache(ctx context.Context) error
 	ClearCache() error
 
+	// Credentials
+	RotateAPIKey(newKey string) error
+
 	// Metrics
 	GetMetrics() Metrics
 
@@ -60,7 +72,8 @@ type UserContext struct {
 type FlagResult struct {
 	Key         string      `json:"key"`
 	Value       interface{} `json:"value"`
-	Reason      string      `json:"reason"`
+	Reason      Reason      `json:"reason"`
+	ErrorCode   ErrorCode   `json:"error_code,omitempty"`
 	RuleID      string      `json:"rule_id,omitempty"`
 	Variation   string      `json:"variation,omitempty"`
 	Error       error       `json:"-"`
@@ -68,6 +81,61 @@ type FlagResult struct {
 	CacheHit    bool        `json:"cache_hit"`
 }
 
+// Reason explains why a flag evaluated to its value
+type Reason string
+
+const (
+	// ReasonTargetingMatch means the user matched a targeting rule
+	ReasonTargetingMatch Reason = "TARGETING_MATCH"
+	// ReasonSplit means the value was assigned by a percentage rollout
+	ReasonSplit Reason = "SPLIT"
+	// ReasonDefault means no rule matched and the flag's default was served
+	ReasonDefault Reason = "DEFAULT"
+	// ReasonDisabled means the flag is turned off
+	ReasonDisabled Reason = "DISABLED"
+	// ReasonPrerequisiteFailed means a prerequisite flag did not serve its required
+	// variation; RuleID is "prerequisite:" followed by that flag's key
+	ReasonPrerequisiteFailed Reason = "PREREQUISITE_FAILED"
+	// ReasonCached means the value was served from the local cache
+	ReasonCached Reason = "CACHED"
+	// ReasonStale means an expired cached value was served because the API was unavailable
+	ReasonStale Reason = "STALE"
+	// ReasonRateLimited means the client-side rate limit was reached and the
+	// cached or default value was served without calling the API
+	ReasonRateLimited Reason = "RATE_LIMITED"
+	// ReasonError means evaluation failed and the caller's default was served; see ErrorCode
+	ReasonError Reason = "ERROR"
+)
+
+// ErrorCode classifies why an evaluation failed
+type ErrorCode string
+
+const (
+	// ErrorCodeFlagNotFound means the flag does not exist in the environment
+	ErrorCodeFlagNotFound ErrorCode = "FLAG_NOT_FOUND"
+	// ErrorCodeTypeMismatch means the flag value could not be converted to the requested type
+	ErrorCodeTypeMismatch ErrorCode = "TYPE_MISMATCH"
+	// ErrorCodeProviderNotReady means the SDK has no ruleset or data to evaluate with yet
+	ErrorCodeProviderNotReady ErrorCode = "PROVIDER_NOT_READY"
+	// ErrorCodeNetwork means the Variably API could not be reached, timed out or failed with a server error
+	ErrorCodeNetwork ErrorCode = "NETWORK"
+	// ErrorCodeGeneral covers any other evaluation failure, such as a malformed flag definition
+	// or a request the API rejected as unauthorized or invalid
+	ErrorCodeGeneral ErrorCode = "GENERAL"
+)
+
+// ExperimentAssignment describes the variant of an experiment a user is assigned to
+type ExperimentAssignment struct {
+	ExperimentKey string                 `json:"experiment_key"`
+	Variant       string                 `json:"variant"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	InExperiment  bool                   `json:"in_experiment"`
+	AssignedAt    time.Time              `json:"assigned_at"`
+}
+
+// ExposureEventName is the name of the event recorded whenever a user is exposed to an experiment variant
+const ExposureEventName = "experiment_exposure"
+
 // Event represents a tracking event for analytics
 type Event struct {
 	Name       string                 `json:"event_name"`
@@ -81,6 +149,22 @@ type Event struct {
 // UpdateCallback is called when a flag value changes in real-time
 type UpdateCallback func(flagKey string, newValue FlagResult)
 
+// Subscription is a callback registered with Subscribe
+type Subscription struct {
+	once        sync.Once
+	unsubscribe func()
+}
+
+// Unsubscribe removes the subscription's callback, leaving other subscribers
+// to the same flags in place. It is safe to call more than once.
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		if s.unsubscribe != nil {
+			s.unsubscribe()
+		}
+	})
+}
+
 // Metrics provides SDK performance and usage statistics
 type Metrics struct {
 	APICalls        int64         `json:"api_calls"`
@@ -96,6 +180,34 @@ type Metrics struct {
 	FlagsEvaluated  int64         `json:"flags_evaluated"`
 	GatesEvaluated  int64         `json:"gates_evaluated"`
 	EventsTracked   int64         `json:"events_tracked"`
+
+	// Times any circuit breaker opened, and each endpoint's current breaker state
+	CircuitBreakerTrips  int64                   `json:"circuit_breaker_trips"`
+	CircuitBreakerStates map[string]CircuitState `json:"circuit_breaker_states,omitempty"`
+
+	// Requests that moved on to another endpoint, and per-endpoint request counts and health
+	Failovers int64                    `json:"failovers"`
+	Endpoints map[string]EndpointStats `json:"endpoints,omitempty"`
+
+	// Request and response body bytes on the wire, after compression
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+
+	// Requests rejected by the client-side rate limiter without reaching the API
+	RateLimitedRequests int64 `json:"rate_limited_requests"`
+
+	// Subscribe callbacks and Watch channels currently registered
+	ActiveSubscribers int64 `json:"active_subscribers"`
+
+	// Events dropped because the queue of events waiting to be sent was full
+	EventsDropped int64 `json:"events_dropped"`
+}
+
+// EndpointStats holds the requests made to an API endpoint and its current health
+type EndpointStats struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+	Healthy  bool  `json:"healthy"`
 }
 
 // Logger interface for custom logging implementations
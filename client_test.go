@@ -2,6 +2,7 @@ package variably
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 )
@@ -150,6 +151,28 @@ func TestMockClient(t *testing.T) {
 	})
 }
 
+func TestMockWatch(t *testing.T) {
+	client := NewMockClient()
+	client.SetFlagValue("color", "blue")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := client.Watch(ctx, "color", UserContext{UserID: "u1"})
+
+	if result := <-results; result.Value != "blue" {
+		t.Errorf("Expected blue, got %v", result.Value)
+	}
+
+	client.SetFlagValue("color", "blue")
+	client.SetFlagValue("color", "green")
+	if result := <-results; result.Value != "green" {
+		t.Errorf("Expected green, got %v", result.Value)
+	}
+
+	cancel()
+	for range results {
+	}
+}
+
 func TestConfig(t *testing.T) {
 	t.Run("Default Config", func(t *testing.T) {
 		config := DefaultConfig()
@@ -288,4 +311,85 @@ func TestMetrics(t *testing.T) {
 	if summary.CacheHitRate != 50.0 {
 		t.Errorf("Expected 50%% cache hit rate, got %.2f%%", summary.CacheHitRate)
 	}
-}
\ No newline at end of file
+}
+
+func TestTypedEvaluation(t *testing.T) {
+	client := NewMockClient()
+	ctx := context.Background()
+	user := UserContext{UserID: "test_user"}
+
+	client.SetFlagValue("theme", map[string]interface{}{"name": "dark", "accents": []interface{}{"red", "blue"}})
+	client.SetFlagValue("limit", float64(250))
+	client.SetFlagValue("fraction", 2.5)
+	client.SetFlagValue("timeout", "1500ms")
+	client.SetFlagValue("timeout_ms", float64(250))
+	client.SetFlagValue("level", "high")
+
+	theme := Evaluate(ctx, client, "theme", testTheme{Name: "light"}, user)
+	if theme.Name != "dark" || len(theme.Accents) != 2 {
+		t.Errorf("Expected decoded dark theme, got %+v", theme)
+	}
+
+	if limit := Evaluate[uint16](ctx, client, "limit", 10, user); limit != 250 {
+		t.Errorf("Expected 250, got %v", limit)
+	}
+
+	if limit := Evaluate[int8](ctx, client, "limit", 10, user); limit != 10 {
+		t.Errorf("Expected default on int8 overflow, got %v", limit)
+	}
+
+	detail := EvaluateDetail[int64](ctx, client, "fraction", 7, user)
+	if detail.Value != 7 || detail.Result.Error == nil {
+		t.Errorf("Expected default with error for fractional integer, got %v", detail.Value)
+	}
+
+	if timeout := Evaluate(ctx, client, "timeout", time.Second, user); timeout != 1500*time.Millisecond {
+		t.Errorf("Expected 1.5s, got %v", timeout)
+	}
+
+	if timeout := Evaluate(ctx, client, "timeout_ms", time.Second, user); timeout != 250*time.Millisecond {
+		t.Errorf("Expected 250ms, got %v", timeout)
+	}
+
+	if level := Evaluate[testLevel](ctx, client, "level", 0, user); level != 2 {
+		t.Errorf("Expected level 2, got %v", level)
+	}
+
+	if missing := Evaluate(ctx, client, "missing", "fallback", user); missing != "fallback" {
+		t.Errorf("Expected fallback, got %v", missing)
+	}
+
+	// The typed methods keep their original, looser conversions
+	if limit := client.EvaluateFlagInt(ctx, "fraction", 7, user); limit != 2 {
+		t.Errorf("Expected EvaluateFlagInt to truncate, got %v", limit)
+	}
+	client.SetFlagValue("enabled_text", "true")
+	if enabled := client.EvaluateFlagBool(ctx, "enabled_text", false, user); enabled {
+		t.Error("Expected EvaluateFlagBool to reject a string")
+	}
+	client.SetFlagValue("rate_text", "0.5")
+	if rate := client.EvaluateFlagFloat(ctx, "rate_text", 0.1, user); rate != 0.1 {
+		t.Errorf("Expected EvaluateFlagFloat to reject a string, got %v", rate)
+	}
+}
+
+// testTheme is a struct flag value decoded by TestTypedEvaluation
+type testTheme struct {
+	Name    string   `json:"name"`
+	Accents []string `json:"accents"`
+}
+
+// testLevel is decoded from a string flag through encoding.TextUnmarshaler
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level %q", text)
+	}
+	return nil
+}
@@ -2,7 +2,12 @@ package variably
 
 import (
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -15,11 +20,18 @@ type Config struct {
 	BaseURL     string `json:"base_url,omitempty" yaml:"base_url,omitempty"`
 	Environment string `json:"environment,omitempty" yaml:"environment,omitempty"`
 
+	// Endpoints, when set, replaces BaseURL with an ordered list of API
+	// endpoints, such as a primary region, a secondary region and a relay proxy
+	Endpoints []string `json:"endpoints,omitempty" yaml:"endpoints,omitempty"`
+
 	// Performance
 	Timeout        time.Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
 	RetryAttempts  int           `json:"retry_attempts,omitempty" yaml:"retry_attempts,omitempty"`
 	MaxCacheSize   int           `json:"max_cache_size,omitempty" yaml:"max_cache_size,omitempty"`
 
+	// Per-operation timeouts covering every retry of a call
+	TimeoutConfig TimeoutConfig `json:"timeout_config,omitempty" yaml:"timeout_config,omitempty"`
+
 	// Features
 	EnableAnalytics    bool `json:"enable_analytics" yaml:"enable_analytics"`
 	EnableOfflineMode  bool `json:"enable_offline_mode" yaml:"enable_offline_mode"`
@@ -30,6 +42,34 @@ type Config struct {
 	PollingConfig PollingConfig `json:"polling_config,omitempty" yaml:"polling_config,omitempty"`
 	LogConfig     LogConfig     `json:"log_config,omitempty" yaml:"log_config,omitempty"`
 
+	// Real-time updates
+	RealTimeConfig RealTimeConfig `json:"real_time_config,omitempty" yaml:"real_time_config,omitempty"`
+
+	// Server-side local evaluation
+	LocalEvaluationConfig LocalEvaluationConfig `json:"local_evaluation_config,omitempty" yaml:"local_evaluation_config,omitempty"`
+
+	// Automatic micro-batching of single flag evaluations
+	BatchingConfig BatchingConfig `json:"batching_config,omitempty" yaml:"batching_config,omitempty"`
+
+	// Resilience
+	CircuitBreakerConfig CircuitBreakerConfig `json:"circuit_breaker_config,omitempty" yaml:"circuit_breaker_config,omitempty"`
+	FailoverConfig       FailoverConfig       `json:"failover_config,omitempty" yaml:"failover_config,omitempty"`
+	RateLimitConfig      RateLimitConfig      `json:"rate_limit_config,omitempty" yaml:"rate_limit_config,omitempty"`
+
+	// Compression of SDK traffic
+	CompressionConfig CompressionConfig `json:"compression_config,omitempty" yaml:"compression_config,omitempty"`
+
+	// Transport. HTTPClient is used as is when set, ignoring Timeout, ProxyURL
+	// and TLSConfig; otherwise Transport replaces the default transport, which
+	// ignores ProxyURL and TLSConfig.
+	HTTPClient *http.Client      `json:"-" yaml:"-"`
+	Transport  http.RoundTripper `json:"-" yaml:"-"`
+	ProxyURL   string            `json:"proxy_url,omitempty" yaml:"proxy_url,omitempty"`
+	TLSConfig  TLSConfig         `json:"tls_config,omitempty" yaml:"tls_config,omitempty"`
+
+	// Request signing
+	SigningConfig SigningConfig `json:"signing_config,omitempty" yaml:"signing_config,omitempty"`
+
 	// Custom Logger
 	Logger Logger `json:"-" yaml:"-"`
 }
@@ -41,15 +81,127 @@ type CacheConfig struct {
 	EnablePersistence bool          `json:"enable_persistence" yaml:"enable_persistence"`
 	PersistencePath   string        `json:"persistence_path,omitempty" yaml:"persistence_path,omitempty"`
 	EvictionPolicy    string        `json:"eviction_policy,omitempty" yaml:"eviction_policy,omitempty"`
+
+	// How long expired values are kept to serve when the API is unavailable. Zero disables stale values.
+	StaleTTL time.Duration `json:"stale_ttl,omitempty" yaml:"stale_ttl,omitempty"`
+
+	// Attributes that affect flag values and therefore cache keys. When neither
+	// these nor the API specify a flag's attributes, the whole context is used.
+	KeyAttributes     []string            `json:"key_attributes,omitempty" yaml:"key_attributes,omitempty"`
+	FlagKeyAttributes map[string][]string `json:"flag_key_attributes,omitempty" yaml:"flag_key_attributes,omitempty"`
 }
 
-// PollingConfig configures real-time updates
+// PollingConfig configures polling for flag changes, as an alternative to
+// streaming. Each poll waits Interval plus a random delay of up to Jitter.
 type PollingConfig struct {
 	Enabled  bool          `json:"enabled" yaml:"enabled"`
 	Interval time.Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
 	Jitter   time.Duration `json:"jitter,omitempty" yaml:"jitter,omitempty"`
 }
 
+// Real-time transports
+const (
+	RealTimeTransportSSE       = "sse"
+	RealTimeTransportWebSocket = "websocket"
+)
+
+// RealTimeConfig configures how flag changes are received when
+// EnableRealTimeSync is set. Transport is "sse" or "websocket". WebSocket
+// connections send a ping every HeartbeatInterval and are considered dead
+// if nothing is received for two intervals.
+type RealTimeConfig struct {
+	Transport         string        `json:"transport,omitempty" yaml:"transport,omitempty"`
+	HeartbeatInterval time.Duration `json:"heartbeat_interval,omitempty" yaml:"heartbeat_interval,omitempty"`
+}
+
+// BatchingConfig configures automatic micro-batching. When enabled, single
+// flag evaluations for the same user issued within Window of each other are
+// sent together in one batch request of at most MaxBatchSize flags.
+type BatchingConfig struct {
+	Enabled      bool          `json:"enabled" yaml:"enabled"`
+	Window       time.Duration `json:"window,omitempty" yaml:"window,omitempty"`
+	MaxBatchSize int           `json:"max_batch_size,omitempty" yaml:"max_batch_size,omitempty"`
+}
+
+// LocalEvaluationConfig configures server-side local evaluation. When enabled,
+// the client downloads the environment's full ruleset and evaluates flags and
+// gates in-process instead of calling the API for every user.
+type LocalEvaluationConfig struct {
+	Enabled         bool          `json:"enabled" yaml:"enabled"`
+	RefreshInterval time.Duration `json:"refresh_interval,omitempty" yaml:"refresh_interval,omitempty"`
+}
+
+// CircuitBreakerConfig configures the per-endpoint circuit breakers. After
+// FailureThreshold consecutive server errors or timeouts an endpoint's breaker
+// opens and requests fail fast for ResetTimeout, after which up to
+// HalfOpenMaxRequests trial requests decide whether it closes again.
+type CircuitBreakerConfig struct {
+	Enabled             bool          `json:"enabled" yaml:"enabled"`
+	FailureThreshold    int           `json:"failure_threshold,omitempty" yaml:"failure_threshold,omitempty"`
+	ResetTimeout        time.Duration `json:"reset_timeout,omitempty" yaml:"reset_timeout,omitempty"`
+	HalfOpenMaxRequests int           `json:"half_open_max_requests,omitempty" yaml:"half_open_max_requests,omitempty"`
+}
+
+// SigningConfig configures HMAC-SHA256 request signing. Each request carries
+// X-Variably-Timestamp and an X-Variably-Signature over its method, path,
+// timestamp and body hash, keyed with Secret. Secret is required and must
+// differ from the API key, which is sent with every request.
+type SigningConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Secret  string `json:"secret,omitempty" yaml:"secret,omitempty"`
+}
+
+// FailoverConfig configures failover between Endpoints. An endpoint that is
+// unreachable or returns server errors is skipped, and probed every
+// ProbeInterval so that requests fail back once it recovers.
+type FailoverConfig struct {
+	ProbeInterval time.Duration `json:"probe_interval,omitempty" yaml:"probe_interval,omitempty"`
+}
+
+// TimeoutConfig sets overall timeouts for flag, gate and experiment
+// evaluations, batch evaluations and event tracking. Each covers all retries
+// of a call, while Timeout bounds a single attempt. Zero means no limit beyond
+// the caller's context.
+type TimeoutConfig struct {
+	Evaluate time.Duration `json:"evaluate,omitempty" yaml:"evaluate,omitempty"`
+	Batch    time.Duration `json:"batch,omitempty" yaml:"batch,omitempty"`
+	Track    time.Duration `json:"track,omitempty" yaml:"track,omitempty"`
+}
+
+// RateLimitConfig configures the client-side limit on outbound API calls.
+// Requests are limited to RequestsPerSecond with bursts of up to Burst, and to
+// MaxConcurrent in flight. A request that cannot proceed within MaxWait fails
+// and the evaluation serves the cached or default value with reason RATE_LIMITED.
+type RateLimitConfig struct {
+	Enabled           bool          `json:"enabled" yaml:"enabled"`
+	RequestsPerSecond float64       `json:"requests_per_second,omitempty" yaml:"requests_per_second,omitempty"`
+	Burst             int           `json:"burst,omitempty" yaml:"burst,omitempty"`
+	MaxConcurrent     int           `json:"max_concurrent,omitempty" yaml:"max_concurrent,omitempty"`
+	MaxWait           time.Duration `json:"max_wait,omitempty" yaml:"max_wait,omitempty"`
+}
+
+// CompressionConfig configures compression of API traffic. Gzip responses are
+// requested and decoded unless DisableResponseCompression is set. When
+// enabled, request bodies of at least Threshold bytes are also gzipped, which
+// requires an API that accepts compressed requests.
+type CompressionConfig struct {
+	Enabled   bool `json:"enabled" yaml:"enabled"`
+	Threshold int  `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+
+	DisableResponseCompression bool `json:"disable_response_compression,omitempty" yaml:"disable_response_compression,omitempty"`
+}
+
+// TLSConfig configures TLS for the default transport. CAFile adds a PEM
+// bundle of root CAs to the system pool, CertFile and KeyFile hold a client
+// certificate for mTLS, and MinVersion is one of "1.0", "1.1", "1.2" or "1.3".
+type TLSConfig struct {
+	CAFile     string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	CertFile   string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	KeyFile    string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+}
+
 // LogConfig configures logging behavior
 type LogConfig struct {
 	Level  string `json:"level,omitempty" yaml:"level,omitempty"`
@@ -75,6 +227,7 @@ func DefaultConfig() *Config {
 			MaxSize:           1000,
 			EnablePersistence: false,
 			EvictionPolicy:    "LRU",
+			StaleTTL:          time.Hour,
 		},
 
 		PollingConfig: PollingConfig{
@@ -88,6 +241,46 @@ func DefaultConfig() *Config {
 			Format: "text",
 			Output: "stdout",
 		},
+
+		LocalEvaluationConfig: LocalEvaluationConfig{
+			Enabled:         false,
+			RefreshInterval: 30 * time.Second,
+		},
+
+		RealTimeConfig: RealTimeConfig{
+			Transport:         RealTimeTransportSSE,
+			HeartbeatInterval: 30 * time.Second,
+		},
+
+		BatchingConfig: BatchingConfig{
+			Enabled:      false,
+			Window:       2 * time.Millisecond,
+			MaxBatchSize: 50,
+		},
+
+		CircuitBreakerConfig: CircuitBreakerConfig{
+			Enabled:             true,
+			FailureThreshold:    5,
+			ResetTimeout:        30 * time.Second,
+			HalfOpenMaxRequests: 1,
+		},
+
+		FailoverConfig: FailoverConfig{
+			ProbeInterval: 30 * time.Second,
+		},
+
+		RateLimitConfig: RateLimitConfig{
+			Enabled:           false,
+			RequestsPerSecond: 100,
+			Burst:             100,
+			MaxConcurrent:     10,
+			MaxWait:           100 * time.Millisecond,
+		},
+
+		CompressionConfig: CompressionConfig{
+			Enabled:   false,
+			Threshold: 1024,
+		},
 	}
 }
 
@@ -103,6 +296,13 @@ func LoadConfigFromEnv() *Config {
 		config.BaseURL = baseURL
 	}
 
+	if endpoints := os.Getenv("VARIABLY_ENDPOINTS"); endpoints != "" {
+		config.Endpoints = strings.Split(endpoints, ",")
+		for i := range config.Endpoints {
+			config.Endpoints[i] = strings.TrimSpace(config.Endpoints[i])
+		}
+	}
+
 	if env := os.Getenv("VARIABLY_ENVIRONMENT"); env != "" {
 		config.Environment = env
 	}
@@ -123,6 +323,28 @@ func LoadConfigFromEnv() *Config {
 		config.LogConfig.Level = logLevel
 	}
 
+	if localEval := os.Getenv("VARIABLY_LOCAL_EVALUATION"); localEval != "" {
+		if enabled, err := strconv.ParseBool(localEval); err == nil {
+			config.LocalEvaluationConfig.Enabled = enabled
+		}
+	}
+
+	if proxyURL := os.Getenv("VARIABLY_PROXY_URL"); proxyURL != "" {
+		config.ProxyURL = proxyURL
+	}
+
+	if caFile := os.Getenv("VARIABLY_TLS_CA_FILE"); caFile != "" {
+		config.TLSConfig.CAFile = caFile
+	}
+
+	if certFile := os.Getenv("VARIABLY_TLS_CERT_FILE"); certFile != "" {
+		config.TLSConfig.CertFile = certFile
+	}
+
+	if keyFile := os.Getenv("VARIABLY_TLS_KEY_FILE"); keyFile != "" {
+		config.TLSConfig.KeyFile = keyFile
+	}
+
 	return config
 }
 
@@ -149,10 +371,17 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("API key is required")
 	}
 
-	if c.BaseURL == "" {
+	if c.BaseURL == "" && len(c.Endpoints) == 0 {
 		return fmt.Errorf("base URL is required")
 	}
 
+	for _, endpoint := range c.Endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+	}
+
 	if c.Environment == "" {
 		return fmt.Errorf("environment is required")
 	}
@@ -173,6 +402,90 @@ func (c *Config) Validate() error {
 		c.CacheConfig.MaxSize = 1000
 	}
 
+	if c.LocalEvaluationConfig.RefreshInterval <= 0 {
+		c.LocalEvaluationConfig.RefreshInterval = 30 * time.Second
+	}
+
+	if c.PollingConfig.Interval <= 0 {
+		c.PollingConfig.Interval = 30 * time.Second
+	}
+
+	if c.PollingConfig.Jitter < 0 {
+		c.PollingConfig.Jitter = 0
+	}
+
+	if c.RealTimeConfig.HeartbeatInterval <= 0 {
+		c.RealTimeConfig.HeartbeatInterval = 30 * time.Second
+	}
+
+	if c.BatchingConfig.Window <= 0 {
+		c.BatchingConfig.Window = 2 * time.Millisecond
+	}
+
+	if c.BatchingConfig.MaxBatchSize <= 0 {
+		c.BatchingConfig.MaxBatchSize = 50
+	}
+
+	if c.CacheConfig.StaleTTL < 0 {
+		c.CacheConfig.StaleTTL = 0
+	}
+
+	if c.CircuitBreakerConfig.FailureThreshold <= 0 {
+		c.CircuitBreakerConfig.FailureThreshold = 5
+	}
+
+	if c.CircuitBreakerConfig.ResetTimeout <= 0 {
+		c.CircuitBreakerConfig.ResetTimeout = 30 * time.Second
+	}
+
+	if c.CircuitBreakerConfig.HalfOpenMaxRequests <= 0 {
+		c.CircuitBreakerConfig.HalfOpenMaxRequests = 1
+	}
+
+	if c.FailoverConfig.ProbeInterval <= 0 {
+		c.FailoverConfig.ProbeInterval = 30 * time.Second
+	}
+
+	if c.RateLimitConfig.RequestsPerSecond <= 0 {
+		c.RateLimitConfig.RequestsPerSecond = 100
+	}
+
+	if c.RateLimitConfig.Burst <= 0 {
+		c.RateLimitConfig.Burst = int(math.Ceil(c.RateLimitConfig.RequestsPerSecond))
+	}
+
+	if c.RateLimitConfig.MaxConcurrent <= 0 {
+		c.RateLimitConfig.MaxConcurrent = 10
+	}
+
+	if c.RateLimitConfig.MaxWait < 0 {
+		c.RateLimitConfig.MaxWait = 0
+	}
+
+	if c.CompressionConfig.Threshold <= 0 {
+		c.CompressionConfig.Threshold = 1024
+	}
+
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", c.ProxyURL)
+		}
+	}
+
+	if c.SigningConfig.Enabled {
+		if c.SigningConfig.Secret == "" {
+			return fmt.Errorf("signing secret is required when request signing is enabled")
+		}
+		if c.SigningConfig.Secret == c.APIKey {
+			return fmt.Errorf("signing secret must differ from the API key")
+		}
+	}
+
+	if _, err := c.TLSConfig.build(); err != nil {
+		return err
+	}
+
 	validEvictionPolicies := map[string]bool{
 		"LRU": true,
 		"LFU": true,
@@ -182,6 +495,14 @@ func (c *Config) Validate() error {
 		c.CacheConfig.EvictionPolicy = "LRU"
 	}
 
+	validTransports := map[string]bool{
+		RealTimeTransportSSE:       true,
+		RealTimeTransportWebSocket: true,
+	}
+	if !validTransports[c.RealTimeConfig.Transport] {
+		c.RealTimeConfig.Transport = RealTimeTransportSSE
+	}
+
 	validLogLevels := map[string]bool{
 		"debug": true,
 		"info":  true,
@@ -203,6 +524,14 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// endpointURLs returns the API endpoints in order of preference
+func (c *Config) endpointURLs() []string {
+	if len(c.Endpoints) > 0 {
+		return c.Endpoints
+	}
+	return []string{c.BaseURL}
+}
+
 // Copy creates a deep copy of the configuration
 func (c *Config) Copy() *Config {
 	copy := *c
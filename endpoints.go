@@ -0,0 +1,152 @@
+package variably
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// healthPath is probed to decide whether an unhealthy endpoint can be used again
+const healthPath = "/api/v1/sdk/health"
+
+// endpointPool tracks the health of the API endpoints in order of preference.
+// Requests go to the first healthy endpoint and fail over down the list; an
+// endpoint that fails is skipped until a probe or a request shows it is back.
+type endpointPool struct {
+	mutex     sync.Mutex
+	endpoints []*endpointHealth
+}
+
+// endpointHealth is the health of a single endpoint
+type endpointHealth struct {
+	url     string
+	healthy bool
+}
+
+// newEndpointPool creates a pool with every endpoint marked healthy
+func newEndpointPool(urls []string) *endpointPool {
+	pool := &endpointPool{}
+	for _, u := range urls {
+		pool.endpoints = append(pool.endpoints, &endpointHealth{
+			url:     strings.TrimRight(u, "/"),
+			healthy: true,
+		})
+	}
+	return pool
+}
+
+// candidates returns the endpoints to try for a request: healthy endpoints in
+// order of preference, then unhealthy ones as a last resort
+func (p *endpointPool) candidates() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	urls := make([]string, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if e.healthy {
+			urls = append(urls, e.url)
+		}
+	}
+	for _, e := range p.endpoints {
+		if !e.healthy {
+			urls = append(urls, e.url)
+		}
+	}
+	return urls
+}
+
+// unhealthy returns the endpoints currently marked unhealthy
+func (p *endpointPool) unhealthy() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var urls []string
+	for _, e := range p.endpoints {
+		if !e.healthy {
+			urls = append(urls, e.url)
+		}
+	}
+	return urls
+}
+
+// markHealthy records that an endpoint is reachable. It reports whether the
+// endpoint was previously unhealthy.
+func (p *endpointPool) markHealthy(url string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, e := range p.endpoints {
+		if e.url == url {
+			recovered := !e.healthy
+			e.healthy = true
+			return recovered
+		}
+	}
+	return false
+}
+
+// markUnhealthy records that an endpoint failed. It reports whether the
+// endpoint was previously healthy.
+func (p *endpointPool) markUnhealthy(url string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, e := range p.endpoints {
+		if e.url == url {
+			failed := e.healthy
+			e.healthy = false
+			return failed
+		}
+	}
+	return false
+}
+
+// StartHealthProbes periodically probes unhealthy endpoints so that requests
+// fail back to preferred endpoints once they recover
+func (c *HTTPClient) StartHealthProbes(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, url := range c.endpoints.unhealthy() {
+				c.probeEndpoint(url)
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// probeEndpoint checks an endpoint's health endpoint and marks it healthy if it responds
+func (c *HTTPClient) probeEndpoint(url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url+healthPath, nil)
+	if err != nil {
+		return
+	}
+	apiKey, _ := c.keys()
+	c.setRequestHeaders(req, apiKey, nil)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		c.logger.Debug("Endpoint health probe failed", "endpoint", url, "error", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		c.logger.Debug("Endpoint health probe failed", "endpoint", url, "status", resp.StatusCode)
+		return
+	}
+
+	if c.endpoints.markHealthy(url) {
+		c.metrics.RecordEndpointHealth(url, true)
+		c.logger.Info("Endpoint recovered", "endpoint", url)
+	}
+}
@@ -1,8 +1,10 @@
 package variably
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 // SDKError represents a Variably SDK error
@@ -29,6 +31,8 @@ type NetworkError struct {
 	*SDKError
 	StatusCode int    `json:"status_code,omitempty"`
 	URL        string `json:"url,omitempty"`
+	// RetryAfter is the delay in seconds the server asked for, e.g. on a 503
+	RetryAfter int `json:"retry_after,omitempty"`
 }
 
 // AuthenticationError represents authentication failures
@@ -66,6 +70,14 @@ type ConfigError struct {
 	Field string `json:"field,omitempty"`
 }
 
+// CircuitOpenError is returned without contacting the API while an endpoint's circuit breaker is open
+type CircuitOpenError struct {
+	*SDKError
+	Endpoint string `json:"endpoint,omitempty"`
+	// RetryAt is when the breaker will next let a trial request through, if known
+	RetryAt time.Time `json:"retry_at,omitempty"`
+}
+
 // NewNetworkError creates a new network error
 func NewNetworkError(message string, statusCode int, url string, cause error) *NetworkError {
 	return &NetworkError{
@@ -118,6 +130,18 @@ func NewRateLimitError(message string, retryAfter int, cause error) *RateLimitEr
 	}
 }
 
+// NewClientRateLimitError creates a rate limit error for requests rejected by
+// the client's own rate limiter. They never reach the API and are not retried.
+func NewClientRateLimitError(message string) *RateLimitError {
+	return &RateLimitError{
+		SDKError: &SDKError{
+			Code:    "CLIENT_RATE_LIMITED",
+			Message: message,
+			Type:    "RateLimitError",
+		},
+	}
+}
+
 // NewTimeoutError creates a new timeout error
 func NewTimeoutError(message, duration string, cause error) *TimeoutError {
 	return &TimeoutError{
@@ -157,6 +181,19 @@ func NewConfigError(message, field string, cause error) *ConfigError {
 	}
 }
 
+// NewCircuitOpenError creates a new circuit open error
+func NewCircuitOpenError(endpoint string, retryAt time.Time) *CircuitOpenError {
+	return &CircuitOpenError{
+		SDKError: &SDKError{
+			Code:    "CIRCUIT_OPEN",
+			Message: fmt.Sprintf("circuit breaker open for %s", endpoint),
+			Type:    "CircuitOpenError",
+		},
+		Endpoint: endpoint,
+		RetryAt:  retryAt,
+	}
+}
+
 // IsRetryable determines if an error is retryable
 func IsRetryable(err error) bool {
 	switch e := err.(type) {
@@ -166,12 +203,19 @@ func IsRetryable(err error) bool {
 	case *TimeoutError:
 		return true
 	case *RateLimitError:
-		return true
+		// Retrying would only add to the load the client limiter is shedding
+		return !IsClientRateLimited(err)
 	default:
 		return false
 	}
 }
 
+// IsClientRateLimited reports whether a request was rejected by the client-side rate limiter
+func IsClientRateLimited(err error) bool {
+	var rateErr *RateLimitError
+	return errors.As(err, &rateErr) && rateErr.Code == "CLIENT_RATE_LIMITED"
+}
+
 // IsTemporary determines if an error is temporary
 func IsTemporary(err error) bool {
 	switch err.(type) {
@@ -181,6 +225,8 @@ func IsTemporary(err error) bool {
 		return true
 	case *RateLimitError:
 		return true
+	case *CircuitOpenError:
+		return true
 	default:
 		return false
 	}
@@ -188,8 +234,12 @@ func IsTemporary(err error) bool {
 
 // GetRetryDelay returns the recommended retry delay for an error
 func GetRetryDelay(err error) int {
-	if rateLimitErr, ok := err.(*RateLimitError); ok && rateLimitErr.RetryAfter > 0 {
-		return rateLimitErr.RetryAfter
+	switch e := err.(type) {
+	case *RateLimitError:
+		return e.RetryAfter
+	case *NetworkError:
+		return e.RetryAfter
+	default:
+		return 0
 	}
-	return 0
 }
\ No newline at end of file
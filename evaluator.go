@@ -2,10 +2,21 @@ package variably
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// segmentsRetryDelay is how long segment downloads are held off after a failure
+const segmentsRetryDelay = 30 * time.Second
+
 // Evaluator handles flag and gate evaluation with caching and fallback logic
 type Evaluator struct {
 	httpClient   *HTTPClient
@@ -13,17 +24,43 @@ type Evaluator struct {
 	metrics      *MetricsCollector
 	logger       Logger
 	config       *Config
+	rulesEngine  *RulesEngine
+	flights      *flightGroup
+	batcher      *flagBatcher
+
+	// Attributes each flag targets on, as reported by the API
+	targetedAttributes map[string][]string
+	attributesMutex    sync.RWMutex
+
+	// Segment downloads outside local evaluation mode
+	segmentsRefreshing bool
+	segmentsRetryAt    time.Time
+	segmentsMutex      sync.Mutex
 }
 
 // NewEvaluator creates a new evaluator instance
 func NewEvaluator(httpClient *HTTPClient, cacheManager *CacheManager, metrics *MetricsCollector, logger Logger, config *Config) *Evaluator {
-	return &Evaluator{
+	rulesEngine := NewRulesEngine(logger)
+	rulesEngine.segments = cacheManager.GetSegment
+	rulesEngine.flags = cacheManager.GetFlagDefinition
+
+	e := &Evaluator{
 		httpClient:   httpClient,
 		cacheManager: cacheManager,
 		metrics:      metrics,
 		logger:       logger,
 		config:       config,
+		rulesEngine:  rulesEngine,
+		flights:      newFlightGroup(),
+
+		targetedAttributes: make(map[string][]string),
+	}
+
+	if config.BatchingConfig.Enabled {
+		e.batcher = newFlagBatcher(config.BatchingConfig, e.evaluateFlagsFromAPI)
 	}
+
+	return e
 }
 
 // EvaluateFlag evaluates a single feature flag with caching and fallback
@@ -35,6 +72,11 @@ func (e *Evaluator) EvaluateFlag(ctx context.Context, flagKey string, defaultVal
 		userContext.Timestamp = time.Now()
 	}
 
+	// Evaluate in-process against the downloaded ruleset
+	if e.config.LocalEvaluationConfig.Enabled {
+		return e.evaluateFlagLocally(flagKey, defaultValue, userContext)
+	}
+
 	// Generate cache key
 	cacheKey := e.generateCacheKey(flagKey, userContext)
 
@@ -43,17 +85,38 @@ func (e *Evaluator) EvaluateFlag(ctx context.Context, flagKey string, defaultVal
 		e.metrics.RecordCacheHit()
 		e.logger.Debug("Flag evaluation cache hit", "flag_key", flagKey, "user_id", userContext.UserID)
 		cachedResult.CacheHit = true
+		cachedResult.Reason = ReasonCached
 		return cachedResult
 	}
 
 	e.metrics.RecordCacheMiss()
 
-	// Cache miss, evaluate via API
-	result := e.evaluateFlagFromAPI(ctx, flagKey, defaultValue, userContext)
+	// Cache miss, evaluate via API. Concurrent misses for the same key share one request.
+	result, shared := e.flights.do(ctx, cacheKey, func(ctx context.Context) FlagResult {
+		var result FlagResult
+		if e.batcher != nil {
+			result = e.batcher.Evaluate(ctx, flagKey, userContext)
+		} else {
+			result = e.evaluateFlagFromAPI(ctx, flagKey, defaultValue, userContext)
+		}
 
-	// Cache the result if successful
-	if result.Error == nil {
-		e.cacheManager.Set(cacheKey, result, 0) // Use default TTL
+		// Cache the result if successful
+		if result.Error == nil {
+			e.cacheManager.Set(cacheKey, result, 0) // Use default TTL
+		}
+		return result
+	})
+	if shared {
+		e.logger.Debug("Flag evaluation shared with in-flight request", "flag_key", flagKey, "user_id", userContext.UserID)
+	}
+
+	if result.Error != nil {
+		if stale, found := e.staleResult(flagKey, cacheKey, result); found {
+			return stale
+		}
+		// A shared failure carries the first caller's default
+		result.Key = flagKey
+		result.Value = defaultValue
 	}
 
 	return result
@@ -68,6 +131,14 @@ func (e *Evaluator) EvaluateFlags(ctx context.Context, flagKeys []string, userCo
 		userContext.Timestamp = time.Now()
 	}
 
+	if e.config.LocalEvaluationConfig.Enabled {
+		for _, flagKey := range flagKeys {
+			e.metrics.RecordFlagEvaluation()
+			results[flagKey] = e.evaluateFlagLocally(flagKey, nil, userContext)
+		}
+		return results
+	}
+
 	// Check cache for each flag
 	var uncachedFlags []string
 	for _, flagKey := range flagKeys {
@@ -78,6 +149,7 @@ func (e *Evaluator) EvaluateFlags(ctx context.Context, flagKeys []string, userCo
 			e.metrics.RecordCacheHit()
 			e.logger.Debug("Flag evaluation cache hit", "flag_key", flagKey, "user_id", userContext.UserID)
 			cachedResult.CacheHit = true
+			cachedResult.Reason = ReasonCached
 			results[flagKey] = cachedResult
 		} else {
 			e.metrics.RecordCacheMiss()
@@ -95,11 +167,13 @@ func (e *Evaluator) EvaluateFlags(ctx context.Context, flagKeys []string, userCo
 
 	// Merge batch results and cache them
 	for flagKey, result := range batchResults {
-		results[flagKey] = result
+		cacheKey := e.generateCacheKey(flagKey, userContext)
 		if result.Error == nil {
-			cacheKey := e.generateCacheKey(flagKey, userContext)
 			e.cacheManager.Set(cacheKey, result, 0)
+		} else if stale, found := e.staleResult(flagKey, cacheKey, result); found {
+			result = stale
 		}
+		results[flagKey] = result
 	}
 
 	return results
@@ -114,6 +188,10 @@ func (e *Evaluator) EvaluateGate(ctx context.Context, gateKey string, userContex
 		userContext.Timestamp = time.Now()
 	}
 
+	if e.config.LocalEvaluationConfig.Enabled {
+		return e.evaluateGateLocally(gateKey, userContext)
+	}
+
 	// Generate cache key for gate
 	cacheKey := e.generateGateCacheKey(gateKey, userContext)
 
@@ -128,25 +206,39 @@ func (e *Evaluator) EvaluateGate(ctx context.Context, gateKey string, userContex
 
 	e.metrics.RecordCacheMiss()
 
-	// Cache miss, evaluate via API
-	response, err := e.httpClient.EvaluateGate(ctx, gateKey, userContext, e.config.Environment)
-	if err != nil {
-		e.logger.Error("Failed to evaluate gate", "gate_key", gateKey, "error", err)
-		return false // Default to false for gates
-	}
+	// Cache miss, evaluate via API. Concurrent misses for the same key share one request.
+	result, _ := e.flights.do(ctx, cacheKey, func(ctx context.Context) FlagResult {
+		response, err := e.httpClient.EvaluateGate(ctx, gateKey, userContext, e.config.Environment)
+		if err != nil {
+			return FlagResult{Key: gateKey, Value: false, Reason: ReasonError, ErrorCode: apiErrorCode(err), Error: err, EvaluatedAt: time.Now()}
+		}
 
-	// Cache the result
-	result := FlagResult{
-		Key:         gateKey,
-		Value:       response.Enabled,
-		Reason:      "api_evaluation",
-		EvaluatedAt: time.Now(),
-		CacheHit:    false,
+		// Cache the result
+		result := FlagResult{
+			Key:         gateKey,
+			Value:       response.Enabled,
+			Reason:      ReasonDefault,
+			EvaluatedAt: time.Now(),
+			CacheHit:    false,
+		}
+		e.cacheManager.Set(cacheKey, result, 0)
+		return result
+	})
+
+	if result.Error != nil {
+		e.logger.Error("Failed to evaluate gate", "gate_key", gateKey, "error", result.Error)
+		if stale, found := e.cacheManager.GetStale(cacheKey); found {
+			if value, ok := stale.Value.(bool); ok {
+				e.logger.Debug("Serving stale gate value", "gate_key", gateKey)
+				return value
+			}
+		}
+		return false // Default to false for gates
 	}
-	e.cacheManager.Set(cacheKey, result, 0)
 
-	e.logger.Debug("Gate evaluation successful", "gate_key", gateKey, "enabled", response.Enabled)
-	return response.Enabled
+	enabled, _ := result.Value.(bool)
+	e.logger.Debug("Gate evaluation successful", "gate_key", gateKey, "enabled", enabled)
+	return enabled
 }
 
 // EvaluateGates evaluates multiple feature gates in batch
@@ -158,6 +250,14 @@ func (e *Evaluator) EvaluateGates(ctx context.Context, gateKeys []string, userCo
 		userContext.Timestamp = time.Now()
 	}
 
+	if e.config.LocalEvaluationConfig.Enabled {
+		for _, gateKey := range gateKeys {
+			e.metrics.RecordGateEvaluation()
+			results[gateKey] = e.evaluateGateLocally(gateKey, userContext)
+		}
+		return results
+	}
+
 	// Check cache for each gate
 	var uncachedGates []string
 	for _, gateKey := range gateKeys {
@@ -187,9 +287,14 @@ func (e *Evaluator) EvaluateGates(ctx context.Context, gateKeys []string, userCo
 	response, err := e.httpClient.EvaluateGates(ctx, uncachedGates, userContext, e.config.Environment)
 	if err != nil {
 		e.logger.Error("Failed to evaluate gates batch", "error", err)
-		// Set defaults for uncached gates
+		// Serve stale values where available, otherwise default uncached gates to false
 		for _, gateKey := range uncachedGates {
 			results[gateKey] = false
+			if stale, found := e.cacheManager.GetStale(e.generateGateCacheKey(gateKey, userContext)); found {
+				if value, ok := stale.Value.(bool); ok {
+					results[gateKey] = value
+				}
+			}
 		}
 		return results
 	}
@@ -202,7 +307,7 @@ func (e *Evaluator) EvaluateGates(ctx context.Context, gateKeys []string, userCo
 		result := FlagResult{
 			Key:         gateKey,
 			Value:       gateResult.Enabled,
-			Reason:      "api_evaluation",
+			Reason:      ReasonDefault,
 			EvaluatedAt: time.Now(),
 			CacheHit:    false,
 		}
@@ -213,32 +318,271 @@ func (e *Evaluator) EvaluateGates(ctx context.Context, gateKeys []string, userCo
 	return results
 }
 
-// evaluateFlagFromAPI evaluates a single flag via API with fallback handling
-func (e *Evaluator) evaluateFlagFromAPI(ctx context.Context, flagKey string, defaultValue interface{}, userContext UserContext) FlagResult {
-	response, err := e.httpClient.EvaluateFlag(ctx, flagKey, userContext, e.config.Environment)
+// AllFlags evaluates every flag and gate for a user. Results fetched from the
+// API are cached so later single-flag evaluations for the user are cache hits.
+func (e *Evaluator) AllFlags(ctx context.Context, userContext UserContext) (FlagsState, error) {
+	// Ensure user context has timestamp
+	if userContext.Timestamp.IsZero() {
+		userContext.Timestamp = time.Now()
+	}
+
+	state := FlagsState{
+		Flags:       make(map[string]FlagResult),
+		Gates:       make(map[string]bool),
+		EvaluatedAt: time.Now(),
+	}
+
+	if e.config.LocalEvaluationConfig.Enabled {
+		ruleset := e.cacheManager.GetRuleset()
+		if ruleset == nil {
+			return state, fmt.Errorf("ruleset not loaded")
+		}
+
+		for flagKey := range ruleset.Flags {
+			e.metrics.RecordFlagEvaluation()
+			state.Flags[flagKey] = e.evaluateFlagLocally(flagKey, nil, userContext)
+		}
+		for gateKey := range ruleset.Gates {
+			e.metrics.RecordGateEvaluation()
+			state.Gates[gateKey] = e.evaluateGateLocally(gateKey, userContext)
+		}
+		state.Valid = true
+		return state, nil
+	}
+
+	response, err := e.httpClient.EvaluateAll(ctx, userContext, e.config.Environment)
 	if err != nil {
-		e.logger.Error("Failed to evaluate flag", "flag_key", flagKey, "error", err)
-		
-		// Return default value with error
-		return FlagResult{
-			Key:         flagKey,
-			Value:       defaultValue,
-			Reason:      "error_fallback",
-			Error:       err,
+		e.logger.Error("Failed to evaluate all flags", "error", err)
+		return state, err
+	}
+
+	for flagKey, flagResponse := range response.Flags {
+		e.metrics.RecordFlagEvaluation()
+		result := e.resultFromResponse(flagKey, &flagResponse)
+		state.Flags[flagKey] = result
+		e.cacheManager.Set(e.generateCacheKey(flagKey, userContext), result, 0)
+	}
+	for gateKey, gateResponse := range response.Gates {
+		e.metrics.RecordGateEvaluation()
+		state.Gates[gateKey] = gateResponse.Enabled
+		e.cacheManager.Set(e.generateGateCacheKey(gateKey, userContext), FlagResult{
+			Key:         gateKey,
+			Value:       gateResponse.Enabled,
+			Reason:      ReasonDefault,
 			EvaluatedAt: time.Now(),
-			CacheHit:    false,
+		}, 0)
+	}
+
+	state.Valid = true
+	e.logger.Debug("All flags evaluated", "flags", len(state.Flags), "gates", len(state.Gates))
+	return state, nil
+}
+
+// GetExperimentAssignment returns a user's experiment assignment, caching it like a flag result
+func (e *Evaluator) GetExperimentAssignment(ctx context.Context, experimentKey string, userContext UserContext) (ExperimentAssignment, error) {
+	// Ensure user context has timestamp
+	if userContext.Timestamp.IsZero() {
+		userContext.Timestamp = time.Now()
+	}
+
+	cacheKey := e.generateExperimentCacheKey(experimentKey, userContext)
+
+	if cachedResult, found := e.cacheManager.Get(cacheKey); found {
+		if assignment, err := coerce[ExperimentAssignment](cachedResult.Value); err == nil {
+			e.metrics.RecordCacheHit()
+			e.logger.Debug("Experiment assignment cache hit", "experiment_key", experimentKey, "user_id", userContext.UserID)
+			return assignment, nil
 		}
 	}
 
-	e.logger.Debug("Flag evaluation successful", "flag_key", flagKey, "enabled", response.Enabled)
+	e.metrics.RecordCacheMiss()
+
+	response, err := e.httpClient.GetExperimentAssignment(ctx, experimentKey, userContext, e.config.Environment)
+	if err != nil {
+		e.logger.Error("Failed to get experiment assignment", "experiment_key", experimentKey, "error", err)
+		return ExperimentAssignment{ExperimentKey: experimentKey}, err
+	}
+
+	assignment := ExperimentAssignment{
+		ExperimentKey: experimentKey,
+		Variant:       response.Variant,
+		Parameters:    response.Parameters,
+		InExperiment:  response.InExperiment,
+		AssignedAt:    time.Now(),
+	}
 
-	return FlagResult{
+	e.cacheManager.Set(cacheKey, FlagResult{
+		Key:         experimentKey,
+		Value:       assignment,
+		Reason:      ReasonSplit,
+		Variation:   assignment.Variant,
+		EvaluatedAt: assignment.AssignedAt,
+	}, 0)
+
+	e.logger.Debug("Experiment assignment successful", "experiment_key", experimentKey, "variant", assignment.Variant, "in_experiment", assignment.InExperiment)
+	return assignment, nil
+}
+
+// IsInSegment reports whether the user belongs to a segment. Outside local
+// evaluation mode, segment definitions are downloaded on first use and
+// refreshed in the background once they are older than the cache TTL.
+func (e *Evaluator) IsInSegment(ctx context.Context, segmentKey string, userContext UserContext) bool {
+	if !e.config.LocalEvaluationConfig.Enabled && e.cacheManager.SegmentsExpired() {
+		e.refreshSegments(ctx)
+	}
+
+	s, exists := e.cacheManager.GetSegment(segmentKey)
+	if !exists {
+		e.logger.Debug("Segment not found", "segment_key", segmentKey)
+		return false
+	}
+
+	member := e.rulesEngine.inSegment(s, userContext, 0)
+	e.logger.Debug("Segment evaluated", "segment_key", segmentKey, "member", member)
+	return member
+}
+
+// refreshSegments downloads expired segment definitions. Concurrent callers
+// share the first download; later refreshes run in the background while the
+// cached definitions keep being served. After a failed download no new attempt
+// is made for segmentsRetryDelay.
+func (e *Evaluator) refreshSegments(ctx context.Context) {
+	e.segmentsMutex.Lock()
+	if e.segmentsRefreshing || time.Now().Before(e.segmentsRetryAt) {
+		e.segmentsMutex.Unlock()
+		return
+	}
+	if !e.cacheManager.HasSegments() {
+		e.segmentsMutex.Unlock()
+		e.flights.do(ctx, "segments", func(ctx context.Context) FlagResult {
+			// Check again inside the flight: one that just finished may have
+			// loaded the segments or failed and set the retry time
+			e.segmentsMutex.Lock()
+			backingOff := time.Now().Before(e.segmentsRetryAt)
+			e.segmentsMutex.Unlock()
+			if backingOff || !e.cacheManager.SegmentsExpired() {
+				return FlagResult{}
+			}
+			return FlagResult{Error: e.loadSegmentsOrBackOff(ctx)}
+		})
+		return
+	}
+	e.segmentsRefreshing = true
+	e.segmentsMutex.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), e.config.Timeout)
+		defer cancel()
+		e.loadSegmentsOrBackOff(ctx)
+
+		e.segmentsMutex.Lock()
+		e.segmentsRefreshing = false
+		e.segmentsMutex.Unlock()
+	}()
+}
+
+// loadSegmentsOrBackOff downloads segments, holding off further attempts if it fails
+func (e *Evaluator) loadSegmentsOrBackOff(ctx context.Context) error {
+	err := e.LoadSegments(ctx)
+	if err != nil {
+		e.logger.Error("Failed to load segments", "error", err, "retry_in", segmentsRetryDelay)
+
+		e.segmentsMutex.Lock()
+		e.segmentsRetryAt = time.Now().Add(segmentsRetryDelay)
+		e.segmentsMutex.Unlock()
+	}
+	return err
+}
+
+// LoadSegments downloads the segment definitions for the configured environment
+func (e *Evaluator) LoadSegments(ctx context.Context) error {
+	response, err := e.httpClient.FetchSegments(ctx, e.config.Environment)
+	if err != nil {
+		return err
+	}
+
+	e.cacheManager.SetSegments(response.Segments)
+	e.logger.Debug("Segments loaded", "version", response.Version, "segments", len(response.Segments))
+	return nil
+}
+
+// staleResult returns an expired cached result to serve in place of a failed
+// API evaluation. Only network failures, including an open circuit, and
+// requests held back by the client rate limiter fall back. A flag the API
+// no longer knows has its cached value dropped so it is never served again.
+func (e *Evaluator) staleResult(flagKey, cacheKey string, failed FlagResult) (FlagResult, bool) {
+	if failed.ErrorCode == ErrorCodeFlagNotFound {
+		e.cacheManager.Delete(cacheKey)
+		return FlagResult{}, false
+	}
+	if failed.ErrorCode != ErrorCodeNetwork && failed.Reason != ReasonRateLimited {
+		return FlagResult{}, false
+	}
+
+	stale, found := e.cacheManager.GetStale(cacheKey)
+	if !found {
+		return FlagResult{}, false
+	}
+
+	e.logger.Debug("Serving stale flag value", "flag_key", flagKey, "error", failed.Error)
+	stale.CacheHit = true
+	stale.Reason = ReasonStale
+	if failed.Reason == ReasonRateLimited {
+		stale.Reason = ReasonRateLimited
+	}
+	return stale, true
+}
+
+// apiErrorCode classifies a failed API call. Only failures to reach the API or
+// get an answer from it are NETWORK; a 404 means the flag does not exist, and
+// other rejections such as bad credentials or requests are GENERAL.
+func apiErrorCode(err error) ErrorCode {
+	var netErr *NetworkError
+	var circuitErr *CircuitOpenError
+	var rateErr *RateLimitError
+	switch {
+	case isEndpointFailure(err), errors.As(err, &circuitErr), errors.As(err, &rateErr),
+		errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeNetwork
+	case errors.As(err, &netErr) && netErr.StatusCode == http.StatusNotFound:
+		return ErrorCodeFlagNotFound
+	default:
+		return ErrorCodeGeneral
+	}
+}
+
+// apiErrorResult is the result of a failed API evaluation, serving value.
+// Requests rejected by the client rate limiter get their own reason.
+func apiErrorResult(flagKey string, value interface{}, err error) FlagResult {
+	result := FlagResult{
 		Key:         flagKey,
-		Value:       response.Enabled,
-		Reason:      "api_evaluation",
+		Value:       value,
+		Reason:      ReasonError,
+		ErrorCode:   apiErrorCode(err),
+		Error:       err,
 		EvaluatedAt: time.Now(),
 		CacheHit:    false,
 	}
+	if IsClientRateLimited(err) {
+		result.Reason = ReasonRateLimited
+		result.ErrorCode = ""
+	}
+	return result
+}
+
+// evaluateFlagFromAPI evaluates a single flag via API with fallback handling
+func (e *Evaluator) evaluateFlagFromAPI(ctx context.Context, flagKey string, defaultValue interface{}, userContext UserContext) FlagResult {
+	response, err := e.httpClient.EvaluateFlag(ctx, flagKey, userContext, e.config.Environment)
+	if err != nil {
+		e.logger.Error("Failed to evaluate flag", "flag_key", flagKey, "error", err)
+		
+		// Return default value with error
+		return apiErrorResult(flagKey, defaultValue, err)
+	}
+
+	e.logger.Debug("Flag evaluation successful", "flag_key", flagKey, "value", response.FlagValue(), "variation", response.Variation)
+
+	return e.resultFromResponse(flagKey, response)
 }
 
 // evaluateFlagsFromAPI evaluates multiple flags via batch API with fallback handling
@@ -251,29 +595,14 @@ func (e *Evaluator) evaluateFlagsFromAPI(ctx context.Context, flagKeys []string,
 		
 		// Return defaults for all flags with error
 		for _, flagKey := range flagKeys {
-			results[flagKey] = FlagResult{
-				Key:         flagKey,
-				Value:       nil, // No default value available in batch
-				Reason:      "error_fallback",
-				Error:       err,
-				EvaluatedAt: time.Now(),
-				CacheHit:    false,
-			}
+			results[flagKey] = apiErrorResult(flagKey, nil, err) // No default value available in batch
 		}
 		return results
 	}
 
 	// Process successful results
 	for flagKey, flagResult := range response.Results {
-		results[flagKey] = FlagResult{
-			Key:         flagKey,
-			Value:       flagResult.Enabled,
-			Reason:      "api_evaluation",
-			RuleID:      "",
-			Variation:   "",
-			EvaluatedAt: time.Now(),
-			CacheHit:    false,
-		}
+		results[flagKey] = e.resultFromResponse(flagKey, &flagResult)
 	}
 
 	// Add missing flags as errors (shouldn't happen with a good API)
@@ -282,7 +611,8 @@ func (e *Evaluator) evaluateFlagsFromAPI(ctx context.Context, flagKeys []string,
 			results[flagKey] = FlagResult{
 				Key:         flagKey,
 				Value:       nil,
-				Reason:      "not_found",
+				Reason:      ReasonError,
+				ErrorCode:   ErrorCodeFlagNotFound,
 				Error:       fmt.Errorf("flag not found in response"),
 				EvaluatedAt: time.Now(),
 				CacheHit:    false,
@@ -293,26 +623,259 @@ func (e *Evaluator) evaluateFlagsFromAPI(ctx context.Context, flagKeys []string,
 	return results
 }
 
-// generateCacheKey creates a cache key for flag evaluation
+// resultFromResponse converts an API evaluation response into a FlagResult
+func (e *Evaluator) resultFromResponse(flagKey string, response *EvaluateFlagResponse) FlagResult {
+	e.learnTargetedAttributes(flagKey, response.TargetingAttributes)
+
+	return FlagResult{
+		Key:         flagKey,
+		Value:       response.FlagValue(),
+		Reason:      parseReason(response.Reason, response.RuleID),
+		RuleID:      response.RuleID,
+		Variation:   response.Variation,
+		EvaluatedAt: time.Now(),
+		CacheHit:    false,
+	}
+}
+
+// parseReason maps the reason reported by the API onto a Reason. Responses
+// without a recognised reason are attributed to their rule, if any.
+func parseReason(reason, ruleID string) Reason {
+	switch strings.ToUpper(reason) {
+	case "TARGETING_MATCH", "RULE_MATCH":
+		return ReasonTargetingMatch
+	case "SPLIT", "ROLLOUT", "PERCENTAGE_ROLLOUT":
+		return ReasonSplit
+	case "DEFAULT", "FALLTHROUGH":
+		return ReasonDefault
+	case "DISABLED", "OFF":
+		return ReasonDisabled
+	case "PREREQUISITE_FAILED":
+		return ReasonPrerequisiteFailed
+	}
+
+	if ruleID != "" {
+		return ReasonTargetingMatch
+	}
+	return ReasonDefault
+}
+
+// evaluateFlagLocally evaluates a flag against the downloaded ruleset
+func (e *Evaluator) evaluateFlagLocally(flagKey string, defaultValue interface{}, userContext UserContext) FlagResult {
+	ruleset := e.cacheManager.GetRuleset()
+	if ruleset == nil {
+		return FlagResult{
+			Key:         flagKey,
+			Value:       defaultValue,
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeProviderNotReady,
+			Error:       fmt.Errorf("ruleset not loaded"),
+			EvaluatedAt: time.Now(),
+			CacheHit:    false,
+		}
+	}
+
+	def, exists := ruleset.Flags[flagKey]
+	if !exists {
+		return FlagResult{
+			Key:         flagKey,
+			Value:       defaultValue,
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeFlagNotFound,
+			Error:       fmt.Errorf("flag not found in ruleset"),
+			EvaluatedAt: time.Now(),
+			CacheHit:    false,
+		}
+	}
+
+	result := e.rulesEngine.EvaluateFlag(def, defaultValue, userContext)
+	result.Key = flagKey
+
+	e.logger.Debug("Flag evaluated locally", "flag_key", flagKey, "reason", result.Reason, "variation", result.Variation)
+	return result
+}
+
+// evaluateGateLocally evaluates a gate against the downloaded ruleset
+func (e *Evaluator) evaluateGateLocally(gateKey string, userContext UserContext) bool {
+	ruleset := e.cacheManager.GetRuleset()
+	if ruleset == nil {
+		e.logger.Debug("Ruleset not loaded, gate defaults to false", "gate_key", gateKey)
+		return false
+	}
+
+	def, exists := ruleset.Gates[gateKey]
+	if !exists {
+		e.logger.Debug("Gate not found in ruleset", "gate_key", gateKey)
+		return false
+	}
+
+	enabled := e.rulesEngine.EvaluateGate(def, userContext)
+	e.logger.Debug("Gate evaluated locally", "gate_key", gateKey, "enabled", enabled)
+	return enabled
+}
+
+// LoadRuleset downloads the ruleset for the configured environment and stores it
+func (e *Evaluator) LoadRuleset(ctx context.Context) error {
+	ruleset, err := e.httpClient.FetchRuleset(ctx, e.config.Environment)
+	if err != nil {
+		return err
+	}
+
+	e.cacheManager.SetRuleset(ruleset)
+	e.logger.Debug("Ruleset loaded", "version", ruleset.Version, "flags", len(ruleset.Flags), "gates", len(ruleset.Gates), "segments", len(ruleset.Segments))
+	return nil
+}
+
+// generateCacheKey creates a cache key for flag evaluation. The key includes a
+// fingerprint of the user context restricted to the attributes the flag targets on.
 func (e *Evaluator) generateCacheKey(flagKey string, userContext UserContext) string {
-	// Create a simple cache key based on flag and user ID
-	// In production, you might want to include more context attributes
-	// that affect targeting rules
-	return fmt.Sprintf("flag:%s:user:%s:env:%s", flagKey, userContext.UserID, e.config.Environment)
+	return fmt.Sprintf("flag:%s:env:%s:ctx:%s", flagKey, e.config.Environment, fingerprintUser(userContext, e.cacheKeyAttributes(flagKey)))
 }
 
 // generateGateCacheKey creates a cache key for gate evaluation
 func (e *Evaluator) generateGateCacheKey(gateKey string, userContext UserContext) string {
-	return fmt.Sprintf("gate:%s:user:%s:env:%s", gateKey, userContext.UserID, e.config.Environment)
+	return fmt.Sprintf("gate:%s:env:%s:ctx:%s", gateKey, e.config.Environment, fingerprintUser(userContext, e.cacheKeyAttributes(gateKey)))
+}
+
+// generateExperimentCacheKey creates a cache key for experiment assignments
+func (e *Evaluator) generateExperimentCacheKey(experimentKey string, userContext UserContext) string {
+	return fmt.Sprintf("experiment:%s:env:%s:ctx:%s", experimentKey, e.config.Environment, fingerprintUser(userContext, e.cacheKeyAttributes(experimentKey)))
+}
+
+// cacheKeyAttributes returns the attributes that affect a flag's value, or nil if
+// unknown. Declared per-flag attributes win over those reported by the API, which
+// win over the globally declared attributes.
+func (e *Evaluator) cacheKeyAttributes(key string) []string {
+	if attributes, ok := e.config.CacheConfig.FlagKeyAttributes[key]; ok {
+		return attributes
+	}
+
+	e.attributesMutex.RLock()
+	attributes, ok := e.targetedAttributes[key]
+	e.attributesMutex.RUnlock()
+	if ok {
+		return attributes
+	}
+
+	return e.config.CacheConfig.KeyAttributes
+}
+
+// learnTargetedAttributes records the attributes the API reports a flag targets on
+func (e *Evaluator) learnTargetedAttributes(flagKey string, attributes []string) {
+	if attributes == nil {
+		return
+	}
+
+	e.attributesMutex.Lock()
+	e.targetedAttributes[flagKey] = attributes
+	e.attributesMutex.Unlock()
+}
+
+// userContextFields lists the built-in UserContext fields by attribute name
+var userContextFields = []string{
+	"user_id", "session_id", "email", "country", "language",
+	"platform", "version", "ip_address", "user_agent",
+}
+
+// fingerprintUser returns a stable hash of the user context. When attributes is
+// nil every field and custom attribute is included; otherwise only the listed
+// attributes plus the user's identity are. The timestamp is never included.
+func fingerprintUser(userContext UserContext, attributes []string) string {
+	var names []string
+	if attributes == nil {
+		names = append(names, userContextFields...)
+		for name := range userContext.Attributes {
+			names = append(names, name)
+		}
+	} else {
+		// Identity always matters for rollouts; anonymous users fall back to their session
+		names = append(names, "user_id")
+		if userContext.UserID == "" {
+			names = append(names, "session_id")
+		}
+		names = append(names, attributes...)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	previous := ""
+	for _, name := range names {
+		if name == previous {
+			continue
+		}
+		previous = name
+
+		value, ok := userAttribute(userContext, name)
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded = []byte(fmt.Sprint(value))
+		}
+		hash.Write([]byte(name))
+		hash.Write([]byte{'='})
+		hash.Write(encoded)
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
 }
 
-// RefreshCache clears all cached values to force fresh evaluation
+// RefreshCache clears all cached values to force fresh evaluation.
+// In local evaluation mode the ruleset is downloaded again; otherwise
+// segments are downloaded again on next use.
 func (e *Evaluator) RefreshCache(ctx context.Context) error {
 	e.cacheManager.Clear()
 	e.logger.Info("Cache refreshed - all cached values cleared")
+
+	if e.config.LocalEvaluationConfig.Enabled {
+		return e.LoadRuleset(ctx)
+	}
+	e.cacheManager.ExpireSegments()
 	return nil
 }
 
+// InvalidateFlag removes every cached value of a flag or gate, for all users,
+// so that the next evaluation fetches the current value
+func (e *Evaluator) InvalidateFlag(key string) {
+	deleted := e.cacheManager.DeletePrefix("flag:"+key+":") + e.cacheManager.DeletePrefix("gate:"+key+":")
+	e.logger.Debug("Invalidated cached values", "key", key, "entries", deleted)
+}
+
+// ApplyFlagValue updates cached values of a flag with its new
+// environment-wide value. Only users who were served the default value get
+// it; values that came from targeting, a split or a prerequisite may no
+// longer apply and are dropped instead, as are cached gate values.
+func (e *Evaluator) ApplyFlagValue(update FlagUpdateMessage) {
+	if update.Deleted {
+		e.InvalidateFlag(update.FlagKey)
+		return
+	}
+
+	prefix := "flag:" + update.FlagKey + ":"
+	applied, deleted := 0, 0
+	for _, cacheKey := range e.cacheManager.Keys() {
+		if !strings.HasPrefix(cacheKey, prefix) {
+			continue
+		}
+		result, found := e.cacheManager.Get(cacheKey)
+		if !found || result.Reason != ReasonDefault {
+			e.cacheManager.Delete(cacheKey)
+			deleted++
+			continue
+		}
+		result.Value = update.FlagValue()
+		result.Variation = update.Variation
+		result.EvaluatedAt = time.Now()
+		e.cacheManager.Set(cacheKey, result, 0)
+		applied++
+	}
+	deleted += e.cacheManager.DeletePrefix("gate:" + update.FlagKey + ":")
+
+	e.logger.Debug("Applied flag value to cached values", "key", update.FlagKey, "applied", applied, "deleted", deleted)
+}
+
 // ClearCache alias for RefreshCache for backward compatibility
 func (e *Evaluator) ClearCache() error {
 	return e.RefreshCache(context.Background())
@@ -0,0 +1,520 @@
+package variably
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient creates a client pointed at a test server with quiet logging
+func newTestClient(t *testing.T, handler http.HandlerFunc, configure func(*Config)) (*VariablyClient, *httptest.Server) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+
+	config := DefaultConfig()
+	config.APIKey = "test-key"
+	config.BaseURL = server.URL
+	config.RetryAttempts = 0
+	config.Logger = NewNoOpLogger()
+	if configure != nil {
+		configure(config)
+	}
+
+	client, err := NewClient(config)
+	if err != nil {
+		server.Close()
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client.(*VariablyClient), server
+}
+
+func TestMultivariateEvaluation(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/evaluate":
+			var req EvaluateFlagRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{
+				Enabled:   true,
+				Value:     "dark",
+				Variation: "dark_theme",
+				RuleID:    "rule-1",
+				FlagKey:   req.FlagKey,
+			})
+		case "/api/v1/sdk/evaluate/batch":
+			json.NewEncoder(w).Encode(BatchEvaluateFlagsResponse{Results: map[string]EvaluateFlagResponse{
+				"max_items": {Enabled: true, Value: 25, Variation: "high"},
+				"legacy":    {Enabled: true},
+			}})
+		}
+	}, nil)
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	result := client.EvaluateFlag(ctx, "theme", "light", user)
+	if result.Value != "dark" || result.Variation != "dark_theme" || result.RuleID != "rule-1" {
+		t.Errorf("Expected dark/dark_theme/rule-1, got %v/%v/%v", result.Value, result.Variation, result.RuleID)
+	}
+
+	if theme := client.EvaluateFlagString(ctx, "theme", "light", user); theme != "dark" {
+		t.Errorf("Expected dark, got %v", theme)
+	}
+
+	results := client.EvaluateFlags(ctx, []string{"max_items", "legacy"}, user)
+	if results["max_items"].Value != float64(25) || results["max_items"].Variation != "high" {
+		t.Errorf("Expected 25/high, got %v/%v", results["max_items"].Value, results["max_items"].Variation)
+	}
+	if results["legacy"].Value != true {
+		t.Errorf("Expected boolean flag to fall back to enabled, got %v", results["legacy"].Value)
+	}
+}
+
+func TestTargetingAwareCacheKeys(t *testing.T) {
+	var calls int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		var req EvaluateFlagRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: req.Context.Country == "US", FlagKey: req.FlagKey})
+	}, func(config *Config) {
+		config.CacheConfig.FlagKeyAttributes = map[string][]string{"scoped": {"country"}}
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1", Country: "US", Attributes: map[string]interface{}{"plan": "free"}}
+
+	client.EvaluateFlag(ctx, "unscoped", false, user)
+	if result := client.EvaluateFlag(ctx, "unscoped", false, user); !result.CacheHit {
+		t.Error("Expected identical context to hit the cache")
+	}
+
+	moved := user
+	moved.Country = "DE"
+	if result := client.EvaluateFlag(ctx, "unscoped", false, moved); result.CacheHit || result.Value != false {
+		t.Errorf("Expected country change to miss the cache, got %v (cache hit %v)", result.Value, result.CacheHit)
+	}
+
+	client.EvaluateFlag(ctx, "scoped", false, user)
+	upgraded := user
+	upgraded.Attributes = map[string]interface{}{"plan": "pro"}
+	if result := client.EvaluateFlag(ctx, "scoped", false, upgraded); !result.CacheHit {
+		t.Error("Expected change to an untargeted attribute to hit the cache")
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("Expected 3 API calls, got %d", got)
+	}
+
+	anonA := fingerprintUser(UserContext{SessionID: "s1"}, []string{"country"})
+	anonB := fingerprintUser(UserContext{SessionID: "s2"}, []string{"country"})
+	if anonA == anonB {
+		t.Error("Expected anonymous users with different sessions to have different fingerprints")
+	}
+}
+
+func TestEvaluationReasons(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req EvaluateFlagRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.FlagKey {
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "missing":
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIError{Code: "NOT_FOUND", Message: "flag not found"})
+		case "forbidden":
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(APIError{Code: "UNAUTHORIZED", Message: "invalid API key"})
+		default:
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "on", RuleID: "rule-7", Reason: "rule_match", FlagKey: req.FlagKey})
+		}
+	}, nil)
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	if result := client.EvaluateFlag(ctx, "feature", "off", user); result.Reason != ReasonTargetingMatch {
+		t.Errorf("Expected %s, got %s", ReasonTargetingMatch, result.Reason)
+	}
+	if result := client.EvaluateFlag(ctx, "feature", "off", user); result.Reason != ReasonCached || !result.CacheHit {
+		t.Errorf("Expected %s, got %s", ReasonCached, result.Reason)
+	}
+	if result := client.EvaluateFlag(ctx, "broken", "off", user); result.Reason != ReasonError || result.ErrorCode != ErrorCodeNetwork {
+		t.Errorf("Expected %s/%s, got %s/%s", ReasonError, ErrorCodeNetwork, result.Reason, result.ErrorCode)
+	}
+	if result := client.EvaluateFlag(ctx, "missing", "off", user); result.Reason != ReasonError || result.ErrorCode != ErrorCodeFlagNotFound {
+		t.Errorf("Expected %s/%s, got %s/%s", ReasonError, ErrorCodeFlagNotFound, result.Reason, result.ErrorCode)
+	}
+	if result := client.EvaluateFlag(ctx, "forbidden", "off", user); result.Reason != ReasonError || result.ErrorCode != ErrorCodeGeneral {
+		t.Errorf("Expected %s/%s, got %s/%s", ReasonError, ErrorCodeGeneral, result.Reason, result.ErrorCode)
+	}
+
+	detail := EvaluateDetail(ctx, client, "feature", 10, user)
+	if detail.Value != 10 || detail.Result.ErrorCode != ErrorCodeTypeMismatch {
+		t.Errorf("Expected default with %s, got %v/%s", ErrorCodeTypeMismatch, detail.Value, detail.Result.ErrorCode)
+	}
+
+	mock := NewMockClient()
+	if result := mock.EvaluateFlag(ctx, "unset", false, user); result.Reason != ReasonDefault || result.Error != nil {
+		t.Errorf("Expected mock to serve the default, got %s (%v)", result.Reason, result.Error)
+	}
+	mock.SetStrictFlags(true)
+	if result := mock.EvaluateFlag(ctx, "unset", false, user); result.ErrorCode != ErrorCodeFlagNotFound {
+		t.Errorf("Expected strict mock to report %s, got %s", ErrorCodeFlagNotFound, result.ErrorCode)
+	}
+}
+
+func TestDeletedFlagNotServedStale(t *testing.T) {
+	var deleted int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&deleted) == 1 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIError{Code: "NOT_FOUND", Message: "flag not found"})
+			return
+		}
+		if atomic.LoadInt32(&deleted) == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "blue"})
+	}, func(config *Config) {
+		config.CacheConfig.TTL = 10 * time.Millisecond
+		config.CacheConfig.StaleTTL = time.Hour
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	client.EvaluateFlag(ctx, "color", "red", user)
+	time.Sleep(20 * time.Millisecond)
+
+	atomic.StoreInt32(&deleted, 1)
+	if result := client.EvaluateFlag(ctx, "color", "red", user); result.Value != "red" || result.ErrorCode != ErrorCodeFlagNotFound {
+		t.Errorf("Expected default for deleted flag, got %v (%s)", result.Value, result.ErrorCode)
+	}
+
+	// The expired value is gone, so a later outage does not bring it back
+	atomic.StoreInt32(&deleted, 2)
+	if result := client.EvaluateFlag(ctx, "color", "red", user); result.Value != "red" || result.Reason == ReasonStale {
+		t.Errorf("Expected default after deletion, got %v (%s)", result.Value, result.Reason)
+	}
+}
+
+func TestExperimentAssignment(t *testing.T) {
+	var assignCalls, exposures int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/experiments/assign":
+			atomic.AddInt32(&assignCalls, 1)
+			json.NewEncoder(w).Encode(ExperimentAssignmentResponse{
+				ExperimentKey: "checkout_test",
+				Variant:       "one_page",
+				Parameters:    map[string]interface{}{"steps": 1},
+				InExperiment:  true,
+			})
+		case "/api/v1/sdk/events":
+			var event TrackEventRequest
+			json.NewDecoder(r.Body).Decode(&event)
+			if event.Name == ExposureEventName && event.Properties["variant"] == "one_page" {
+				atomic.AddInt32(&exposures, 1)
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}, nil)
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	for i := 0; i < 2; i++ {
+		assignment, err := client.GetExperimentAssignment(ctx, "checkout_test", user)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !assignment.InExperiment || assignment.Variant != "one_page" || assignment.Parameters["steps"] != float64(1) {
+			t.Errorf("Unexpected assignment: %+v", assignment)
+		}
+	}
+
+	// Exposures are sent in the background, so a cancelled request still records one
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.GetExperimentAssignment(cancelled, "checkout_test", user); err != nil {
+		t.Fatalf("Unexpected error for cached assignment: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&assignCalls); got != 1 {
+		t.Errorf("Expected assignment to be cached after 1 call, got %d calls", got)
+	}
+
+	// Close flushes queued exposures
+	client.Close()
+	if got := atomic.LoadInt32(&exposures); got != 3 {
+		t.Errorf("Expected an exposure per assignment, got %d", got)
+	}
+	if got := client.GetMetrics().EventsTracked; got != 3 {
+		t.Errorf("Expected exposures to be counted as tracked events, got %d", got)
+	}
+}
+
+func TestExposureQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/sdk/experiments/assign" {
+			json.NewEncoder(w).Encode(ExperimentAssignmentResponse{ExperimentKey: "checkout_test", Variant: "one_page", InExperiment: true})
+			return
+		}
+		<-release
+		w.WriteHeader(http.StatusAccepted)
+	}, nil)
+
+	// With the sender stuck on the first exposure, the queue fills up
+	user := UserContext{UserID: "u1"}
+	for i := 0; i < exposureQueueSize+2; i++ {
+		client.GetExperimentAssignment(context.Background(), "checkout_test", user)
+	}
+	if got := client.GetMetrics().EventsDropped; got == 0 {
+		t.Error("Expected exposures beyond the queue size to be counted as dropped")
+	}
+
+	close(release)
+	client.Close()
+}
+
+func TestAllFlags(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/sdk/evaluate/all" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(AllFlagsResponse{
+			Flags: map[string]EvaluateFlagResponse{
+				"theme":    {Value: "dark", Variation: "dark", RuleID: "beta", Reason: "TARGETING_MATCH"},
+				"new_cart": {Enabled: true},
+			},
+			Gates: map[string]EvaluateGateResponse{"admin": {Enabled: true}},
+		})
+	}, nil)
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	state, err := client.AllFlags(ctx, user)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value, _ := state.Value("theme"); value != "dark" || !state.Gate("admin") || !state.Valid {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+
+	// Values are cached for later single-flag evaluations
+	if result := client.EvaluateFlag(ctx, "new_cart", false, user); !result.CacheHit || result.Value != true {
+		t.Errorf("Expected cached value, got %+v", result)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Failed to marshal state: %v", err)
+	}
+	var doc struct {
+		Flags    map[string]interface{}  `json:"flags"`
+		Gates    map[string]bool         `json:"gates"`
+		Metadata map[string]FlagMetadata `json:"flag_metadata"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Failed to parse bootstrap document: %v", err)
+	}
+	if doc.Flags["theme"] != "dark" || !doc.Gates["admin"] || doc.Metadata["theme"].Variation != "dark" || doc.Metadata["theme"].Reason != ReasonTargetingMatch {
+		t.Errorf("Unexpected bootstrap document: %s", data)
+	}
+
+	var decoded FlagsState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to decode state: %v", err)
+	}
+	if decoded.Flags["theme"].RuleID != "beta" || decoded.Flags["new_cart"].Value != true {
+		t.Errorf("Unexpected decoded state: %+v", decoded)
+	}
+}
+
+func TestConcurrentEvaluationsCoalesce(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "dark"})
+	}, nil)
+
+	user := UserContext{UserID: "u1"}
+	cacheKey := client.evaluator.generateCacheKey("theme", user)
+	waiters := func() int {
+		flights := client.evaluator.flights
+		flights.mutex.Lock()
+		defer flights.mutex.Unlock()
+		if f, ok := flights.flights[cacheKey]; ok {
+			return f.waiters
+		}
+		return 0
+	}
+
+	const callers = 20
+	results := make(chan FlagResult, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			results <- client.EvaluateFlag(context.Background(), "theme", "light", user)
+		}()
+	}
+
+	// A caller that gives up gets its own error without failing the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan FlagResult, 1)
+	go func() {
+		cancelled <- client.EvaluateFlag(ctx, "theme", "light", user)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for waiters() < callers+1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if result := <-cancelled; result.Value != "light" || result.Error != context.Canceled {
+		t.Errorf("Expected cancelled caller to get its default, got %v (%v)", result.Value, result.Error)
+	}
+
+	close(release)
+	for i := 0; i < callers; i++ {
+		if result := <-results; result.Value != "dark" {
+			t.Errorf("Expected shared value, got %v (%v)", result.Value, result.Error)
+		}
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("Expected 1 upstream request, got %d", got)
+	}
+}
+
+func TestMicroBatching(t *testing.T) {
+	var batches, singles int32
+	var mutex sync.Mutex
+	var sizes []int
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/sdk/evaluate/batch" {
+			atomic.AddInt32(&singles, 1)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&batches, 1)
+
+		var req BatchEvaluateFlagsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mutex.Lock()
+		sizes = append(sizes, len(req.FlagKeys))
+		mutex.Unlock()
+
+		resp := BatchEvaluateFlagsResponse{Results: make(map[string]EvaluateFlagResponse)}
+		for _, key := range req.FlagKeys {
+			resp.Results[key] = EvaluateFlagResponse{Value: key + ":" + req.Context.UserID}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}, func(config *Config) {
+		config.BatchingConfig.Enabled = true
+		config.BatchingConfig.Window = 50 * time.Millisecond
+		config.BatchingConfig.MaxBatchSize = 3
+	})
+
+	evaluate := func(keys []string, user UserContext) {
+		var wg sync.WaitGroup
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				result := client.EvaluateFlag(context.Background(), key, "default", user)
+				if want := key + ":" + user.UserID; result.Value != want {
+					t.Errorf("Expected %s, got %v (%v)", want, result.Value, result.Error)
+				}
+			}(key)
+		}
+		wg.Wait()
+	}
+
+	evaluate([]string{"a", "b", "c"}, UserContext{UserID: "u1"})
+	if got := atomic.LoadInt32(&batches); got != 1 {
+		t.Errorf("Expected evaluations to share 1 batch, got %d", got)
+	}
+
+	// Different users never share a batch, and batches are capped in size
+	atomic.StoreInt32(&batches, 0)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); evaluate([]string{"d", "e"}, UserContext{UserID: "u2"}) }()
+	go func() { defer wg.Done(); evaluate([]string{"d", "e", "f", "g"}, UserContext{UserID: "u3"}) }()
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&batches); got != 3 {
+		t.Errorf("Expected 3 batches, got %d", got)
+	}
+	mutex.Lock()
+	for _, size := range sizes {
+		if size > 3 {
+			t.Errorf("Expected batches of at most 3 flags, got %d", size)
+		}
+	}
+	mutex.Unlock()
+	if got := atomic.LoadInt32(&singles); got != 0 {
+		t.Errorf("Expected no single evaluation requests, got %d", got)
+	}
+}
+
+func TestMicroBatchingDeadlines(t *testing.T) {
+	abandoned := make(chan bool, 1)
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body is read
+		json.NewDecoder(r.Body).Decode(&BatchEvaluateFlagsRequest{})
+		select {
+		case <-r.Context().Done():
+			abandoned <- true
+		case <-time.After(time.Second):
+			abandoned <- false
+		}
+	}, func(config *Config) {
+		config.BatchingConfig.Enabled = true
+		config.BatchingConfig.Window = 5 * time.Millisecond
+	})
+
+	// The batch request gives up at the latest of its callers' deadlines
+	user := UserContext{UserID: "u1"}
+	var wg sync.WaitGroup
+	timeouts := map[string]time.Duration{"a": 20 * time.Millisecond, "b": 40 * time.Millisecond}
+	for flagKey, timeout := range timeouts {
+		wg.Add(1)
+		go func(flagKey string, timeout time.Duration) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			start := time.Now()
+			result := client.EvaluateFlag(ctx, flagKey, "default", user)
+			if result.Error == nil || time.Since(start) > timeout+50*time.Millisecond {
+				t.Errorf("Expected %s to fail at its own deadline, got %v after %v", flagKey, result.Value, time.Since(start))
+			}
+		}(flagKey, timeout)
+	}
+	wg.Wait()
+
+	if !<-abandoned {
+		t.Error("Expected the batch request to be cancelled at the callers' deadline")
+	}
+}
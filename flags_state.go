@@ -0,0 +1,98 @@
+package variably
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FlagsState is a snapshot of every flag and gate value for a single user.
+// It marshals to a compact JSON document that can be embedded in server-rendered
+// pages to bootstrap client-side SDKs without a round trip.
+type FlagsState struct {
+	Flags       map[string]FlagResult
+	Gates       map[string]bool
+	EvaluatedAt time.Time
+	// Valid is false if the snapshot could not be fully evaluated
+	Valid bool
+}
+
+// FlagMetadata describes how a flag in a bootstrap document was evaluated
+type FlagMetadata struct {
+	Variation string    `json:"variation,omitempty"`
+	Reason    Reason    `json:"reason"`
+	RuleID    string    `json:"rule_id,omitempty"`
+	ErrorCode ErrorCode `json:"error_code,omitempty"`
+}
+
+// flagsStateJSON is the bootstrap document shared with the client-side SDKs
+type flagsStateJSON struct {
+	Flags       map[string]interface{}  `json:"flags"`
+	Gates       map[string]bool         `json:"gates"`
+	Metadata    map[string]FlagMetadata `json:"flag_metadata"`
+	EvaluatedAt time.Time               `json:"evaluated_at"`
+	Valid       bool                    `json:"valid"`
+}
+
+// Value returns a flag's value and whether the flag is present in the snapshot
+func (s FlagsState) Value(flagKey string) (interface{}, bool) {
+	result, ok := s.Flags[flagKey]
+	return result.Value, ok
+}
+
+// Gate returns a gate's value, or false if the gate is not in the snapshot
+func (s FlagsState) Gate(gateKey string) bool {
+	return s.Gates[gateKey]
+}
+
+// MarshalJSON encodes the snapshot as a bootstrap document. Flag values are
+// keyed by flag under "flags" with their evaluation details under "flag_metadata".
+func (s FlagsState) MarshalJSON() ([]byte, error) {
+	doc := flagsStateJSON{
+		Flags:       make(map[string]interface{}, len(s.Flags)),
+		Gates:       s.Gates,
+		Metadata:    make(map[string]FlagMetadata, len(s.Flags)),
+		EvaluatedAt: s.EvaluatedAt,
+		Valid:       s.Valid,
+	}
+	if doc.Gates == nil {
+		doc.Gates = map[string]bool{}
+	}
+
+	for key, result := range s.Flags {
+		doc.Flags[key] = result.Value
+		doc.Metadata[key] = FlagMetadata{
+			Variation: result.Variation,
+			Reason:    result.Reason,
+			RuleID:    result.RuleID,
+			ErrorCode: result.ErrorCode,
+		}
+	}
+
+	return json.Marshal(doc)
+}
+
+// UnmarshalJSON decodes a bootstrap document produced by MarshalJSON
+func (s *FlagsState) UnmarshalJSON(data []byte) error {
+	var doc flagsStateJSON
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	s.Flags = make(map[string]FlagResult, len(doc.Flags))
+	for key, value := range doc.Flags {
+		metadata := doc.Metadata[key]
+		s.Flags[key] = FlagResult{
+			Key:         key,
+			Value:       value,
+			Reason:      metadata.Reason,
+			ErrorCode:   metadata.ErrorCode,
+			RuleID:      metadata.RuleID,
+			Variation:   metadata.Variation,
+			EvaluatedAt: doc.EvaluatedAt,
+		}
+	}
+	s.Gates = doc.Gates
+	s.EvaluatedAt = doc.EvaluatedAt
+	s.Valid = doc.Valid
+	return nil
+}
@@ -9,51 +9,163 @@ import (
 	"math"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // HTTPClient handles all HTTP communication with the Variably API
 type HTTPClient struct {
 	client        *http.Client
-	baseURL       string
-	apiKey        string
+	timeout       time.Duration
 	retryAttempts int
 	logger        Logger
 	metrics       *MetricsCollector
+
+	// Circuit breakers by endpoint and path
+	breakerConfig CircuitBreakerConfig
+	breakers      map[string]*circuitBreaker
+	breakersMutex sync.Mutex
+
+	// API keys; the previous key is kept during a rotation until the new one is accepted
+	apiKey         string
+	previousAPIKey string
+	keyMutex       sync.RWMutex
+
+	// Request signing
+	signing SigningConfig
+
+	// Per-operation timeouts, bounding all attempts of a call
+	timeouts TimeoutConfig
+
+	// Client-side rate limit, nil if disabled
+	limiter *rateLimiter
+
+	// Request and response compression
+	compression CompressionConfig
+
+	// API endpoints in order of preference, probed while unhealthy
+	endpoints     *endpointPool
+	probeInterval time.Duration
+
+	// Client-wide pause requested by the API through Retry-After or rate-limit headers
+	pauseUntil time.Time
+	pauseMutex sync.Mutex
 }
 
+// maxRetryDelay caps how long a request waits before retrying. Requests fail
+// fast rather than wait out longer server-requested pauses.
+const maxRetryDelay = 30 * time.Second
+
 // NewHTTPClient creates a new HTTP client with retry logic and circuit breaker
 func NewHTTPClient(config *Config, logger Logger, metrics *MetricsCollector) *HTTPClient {
-	return &HTTPClient{
-		client: &http.Client{
-			Timeout: config.Timeout,
-			Transport: &http.Transport{
+	client := config.HTTPClient
+	if client == nil {
+		transport, err := newHTTPTransport(config)
+		if err != nil {
+			logger.Error("Invalid transport configuration, using default transport", "error", err)
+			transport = &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
 				MaxIdleConns:        100,
 				MaxIdleConnsPerHost: 10,
 				IdleConnTimeout:     90 * time.Second,
-			},
-		},
-		baseURL:       config.BaseURL,
+			}
+		}
+		client = &http.Client{
+			Timeout:   config.Timeout,
+			Transport: transport,
+		}
+	}
+
+	var limiter *rateLimiter
+	if config.RateLimitConfig.Enabled {
+		limiter = newRateLimiter(config.RateLimitConfig)
+	}
+
+	return &HTTPClient{
+		client:        client,
+		timeout:       config.Timeout,
 		apiKey:        config.APIKey,
 		retryAttempts: config.RetryAttempts,
 		logger:        logger,
 		metrics:       metrics,
+		breakerConfig: config.CircuitBreakerConfig,
+		breakers:      make(map[string]*circuitBreaker),
+		signing:       config.SigningConfig,
+		timeouts:      config.TimeoutConfig,
+		limiter:       limiter,
+		compression:   config.CompressionConfig,
+		endpoints:     newEndpointPool(config.endpointURLs()),
+		probeInterval: config.FailoverConfig.ProbeInterval,
 	}
 }
 
+// breaker returns the circuit breaker for a request path on an endpoint, or
+// nil if circuit breaking is disabled. Each endpoint has its own breakers so
+// that one failing host does not fail requests to its fallbacks.
+func (c *HTTPClient) breaker(baseURL, path string) *circuitBreaker {
+	if !c.breakerConfig.Enabled {
+		return nil
+	}
+
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	endpoint := baseURL + path
+
+	c.breakersMutex.Lock()
+	defer c.breakersMutex.Unlock()
+
+	cb, exists := c.breakers[endpoint]
+	if !exists {
+		cb = newCircuitBreaker(endpoint, c.breakerConfig, c.circuitStateChanged)
+		c.breakers[endpoint] = cb
+	}
+	return cb
+}
+
+// circuitStateChanged logs and records circuit breaker transitions
+func (c *HTTPClient) circuitStateChanged(endpoint string, from, to CircuitState) {
+	c.metrics.RecordCircuitStateChange(endpoint, to)
+
+	if to == CircuitOpen {
+		c.logger.Warn("Circuit breaker opened, failing fast", "endpoint", endpoint, "from", from, "reset_timeout", c.breakerConfig.ResetTimeout)
+		return
+	}
+	c.logger.Info("Circuit breaker state changed", "endpoint", endpoint, "from", from, "to", to)
+}
+
 // EvaluateFlagRequest represents a single flag evaluation request
 type EvaluateFlagRequest struct {
 	FlagKey string      `json:"flag_key"`
 	Context UserContext `json:"context"`
 }
 
-// EvaluateFlagResponse represents a single flag evaluation response
+// EvaluateFlagResponse represents a single flag evaluation response.
+// Value carries the typed value of multivariate flags; boolean flags may
+// omit it, in which case Enabled is the flag's value.
 type EvaluateFlagResponse struct {
-	Enabled   bool   `json:"enabled"`
-	FlagKey   string `json:"flag_key"`
-	UserID    string `json:"user_id"`
-	Timestamp string `json:"timestamp"`
+	Enabled   bool        `json:"enabled"`
+	Value     interface{} `json:"value,omitempty"`
+	Variation string      `json:"variation,omitempty"`
+	RuleID    string      `json:"rule_id,omitempty"`
+	Reason    string      `json:"reason,omitempty"`
+	FlagKey   string      `json:"flag_key"`
+	UserID    string      `json:"user_id"`
+	Timestamp string      `json:"timestamp"`
+
+	// Attributes the flag's rules depend on, used to scope cache keys
+	TargetingAttributes []string `json:"targeting_attributes,omitempty"`
+}
+
+// FlagValue returns the evaluated value, falling back to Enabled for boolean flags
+func (r *EvaluateFlagResponse) FlagValue() interface{} {
+	if r.Value != nil {
+		return r.Value
+	}
+	return r.Enabled
 }
 
 // BatchEvaluateFlagsRequest represents a batch flag evaluation request
@@ -68,6 +180,18 @@ type BatchEvaluateFlagsResponse struct {
 	Results map[string]EvaluateFlagResponse `json:"results"`
 }
 
+// AllFlagsRequest represents a request to evaluate every flag and gate for a user
+type AllFlagsRequest struct {
+	Context     UserContext `json:"context"`
+	Environment string      `json:"environment,omitempty"`
+}
+
+// AllFlagsResponse represents every flag and gate evaluated for a user
+type AllFlagsResponse struct {
+	Flags map[string]EvaluateFlagResponse `json:"flags"`
+	Gates map[string]EvaluateGateResponse `json:"gates"`
+}
+
 // EvaluateGateRequest represents a feature gate evaluation request
 type EvaluateGateRequest struct {
 	GateKey string      `json:"gate_key"`
@@ -96,6 +220,34 @@ type BatchEvaluateGatesResponse struct {
 	Results map[string]EvaluateGateResponse `json:"results"`
 }
 
+// ExperimentAssignmentRequest represents an experiment assignment request
+type ExperimentAssignmentRequest struct {
+	ExperimentKey string      `json:"experiment_key"`
+	Context       UserContext `json:"context"`
+	Environment   string      `json:"environment,omitempty"`
+}
+
+// ExperimentAssignmentResponse represents an experiment assignment response
+type ExperimentAssignmentResponse struct {
+	ExperimentKey string                 `json:"experiment_key"`
+	Variant       string                 `json:"variant"`
+	Parameters    map[string]interface{} `json:"parameters,omitempty"`
+	InExperiment  bool                   `json:"in_experiment"`
+}
+
+// SegmentsResponse represents the segment definitions for an environment
+type SegmentsResponse struct {
+	Version  string                       `json:"version"`
+	Segments map[string]SegmentDefinition `json:"segments"`
+}
+
+// FlagChangesResponse lists the flags changed since a version cursor. Without
+// a cursor it lists every flag, establishing a baseline.
+type FlagChangesResponse struct {
+	Version string              `json:"version"`
+	Changes []FlagUpdateMessage `json:"changes"`
+}
+
 // TrackEventRequest represents an event tracking request
 type TrackEventRequest struct {
 	Name       string                 `json:"name"`
@@ -125,6 +277,9 @@ type APIError struct {
 
 // EvaluateFlag evaluates a single feature flag
 func (c *HTTPClient) EvaluateFlag(ctx context.Context, flagKey string, userContext UserContext, environment string) (*EvaluateFlagResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Evaluate)
+	defer cancel()
+
 	req := EvaluateFlagRequest{
 		FlagKey: flagKey,
 		Context: userContext,
@@ -141,6 +296,9 @@ func (c *HTTPClient) EvaluateFlag(ctx context.Context, flagKey string, userConte
 
 // EvaluateFlags evaluates multiple feature flags in batch
 func (c *HTTPClient) EvaluateFlags(ctx context.Context, flagKeys []string, userContext UserContext, environment string) (*BatchEvaluateFlagsResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Batch)
+	defer cancel()
+
 	req := BatchEvaluateFlagsRequest{
 		FlagKeys:    flagKeys,
 		Context:     userContext,
@@ -156,8 +314,30 @@ func (c *HTTPClient) EvaluateFlags(ctx context.Context, flagKeys []string, userC
 	return &resp, nil
 }
 
+// EvaluateAll evaluates every flag and gate in an environment for a user
+func (c *HTTPClient) EvaluateAll(ctx context.Context, userContext UserContext, environment string) (*AllFlagsResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Batch)
+	defer cancel()
+
+	req := AllFlagsRequest{
+		Context:     userContext,
+		Environment: environment,
+	}
+
+	var resp AllFlagsResponse
+	err := c.makeRequest(ctx, "POST", "/api/v1/sdk/evaluate/all", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
 // EvaluateGate evaluates a single feature gate
 func (c *HTTPClient) EvaluateGate(ctx context.Context, gateKey string, userContext UserContext, environment string) (*EvaluateGateResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Evaluate)
+	defer cancel()
+
 	req := EvaluateGateRequest{
 		GateKey: gateKey,
 		Context: userContext,
@@ -174,6 +354,9 @@ func (c *HTTPClient) EvaluateGate(ctx context.Context, gateKey string, userConte
 
 // EvaluateGates evaluates multiple feature gates in batch
 func (c *HTTPClient) EvaluateGates(ctx context.Context, gateKeys []string, userContext UserContext, environment string) (*BatchEvaluateGatesResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Batch)
+	defer cancel()
+
 	req := BatchEvaluateGatesRequest{
 		GateKeys:    gateKeys,
 		UserID:      userContext.UserID,
@@ -190,8 +373,69 @@ func (c *HTTPClient) EvaluateGates(ctx context.Context, gateKeys []string, userC
 	return &resp, nil
 }
 
+// GetExperimentAssignment fetches a user's variant assignment for an experiment
+func (c *HTTPClient) GetExperimentAssignment(ctx context.Context, experimentKey string, userContext UserContext, environment string) (*ExperimentAssignmentResponse, error) {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Evaluate)
+	defer cancel()
+
+	req := ExperimentAssignmentRequest{
+		ExperimentKey: experimentKey,
+		Context:       userContext,
+		Environment:   environment,
+	}
+
+	var resp ExperimentAssignmentResponse
+	err := c.makeRequest(ctx, "POST", "/api/v1/sdk/experiments/assign", req, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// FetchRuleset downloads the full flag and gate ruleset for an environment
+func (c *HTTPClient) FetchRuleset(ctx context.Context, environment string) (*Ruleset, error) {
+	var resp Ruleset
+	err := c.makeRequest(ctx, "GET", "/api/v1/sdk/ruleset?environment="+url.QueryEscape(environment), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// FetchSegments downloads all segment definitions for an environment
+func (c *HTTPClient) FetchSegments(ctx context.Context, environment string) (*SegmentsResponse, error) {
+	var resp SegmentsResponse
+	err := c.makeRequest(ctx, "GET", "/api/v1/sdk/segments?environment="+url.QueryEscape(environment), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
+// FetchChanges lists flag changes in an environment since the given version
+func (c *HTTPClient) FetchChanges(ctx context.Context, environment, since string) (*FlagChangesResponse, error) {
+	query := url.Values{"environment": {environment}}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var resp FlagChangesResponse
+	err := c.makeRequest(ctx, "GET", "/api/v1/sdk/changes?"+query.Encode(), nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
+
 // TrackEvent tracks a single analytics event
 func (c *HTTPClient) TrackEvent(ctx context.Context, event Event) error {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Track)
+	defer cancel()
+
 	req := TrackEventRequest{
 		Name:       event.Name,
 		UserID:     event.UserID,
@@ -204,6 +448,9 @@ func (c *HTTPClient) TrackEvent(ctx context.Context, event Event) error {
 
 // TrackEvents tracks multiple analytics events in batch
 func (c *HTTPClient) TrackEvents(ctx context.Context, events []Event) error {
+	ctx, cancel := withOperationTimeout(ctx, c.timeouts.Track)
+	defer cancel()
+
 	reqs := make([]TrackEventRequest, len(events))
 	for i, event := range events {
 		reqs[i] = TrackEventRequest{
@@ -221,11 +468,20 @@ func (c *HTTPClient) TrackEvents(ctx context.Context, events []Event) error {
 // makeRequest makes an HTTP request with retry logic and error handling
 func (c *HTTPClient) makeRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
 	var lastErr error
+	var lastLatency time.Duration
 
 	for attempt := 0; attempt <= c.retryAttempts; attempt++ {
-		if attempt > 0 {
+		// Server-requested delays are honoured through the pause below instead
+		if attempt > 0 && GetRetryDelay(lastErr) == 0 {
 			// Calculate exponential backoff with jitter
 			backoff := c.calculateBackoff(attempt)
+
+			// Don't start a retry that would not finish before the caller's deadline,
+			// assuming it takes as long as the last attempt
+			if !withinDeadline(ctx, backoff+lastLatency) {
+				c.logger.Debug("Skipping retry that cannot finish before the deadline", "attempt", attempt, "backoff", backoff, "last_latency", lastLatency)
+				break
+			}
 			c.logger.Debug("Retrying request", "attempt", attempt, "backoff", backoff)
 
 			select {
@@ -235,9 +491,42 @@ func (c *HTTPClient) makeRequest(ctx context.Context, method, path string, body
 			}
 		}
 
+		// Wait out any pause the API requested, shared by all requests.
+		// If the pause is too long to wait for, report why we were retrying.
+		if err := c.waitForPause(ctx); err != nil {
+			if _, paused := err.(*RateLimitError); paused && lastErr != nil {
+				return lastErr
+			}
+			return err
+		}
+
+		// Shed load rather than queue behind the client-side rate limit
+		release := func() {}
+		if c.limiter != nil {
+			var err error
+			if release, err = c.limiter.acquire(ctx); err != nil {
+				if IsClientRateLimited(err) {
+					c.metrics.RecordRateLimited()
+					c.logger.Debug("Client rate limit reached, not sending request", "path", path, "error", err)
+				}
+				if lastErr != nil {
+					return lastErr
+				}
+				return err
+			}
+		}
+
 		startTime := time.Now()
-		err := c.doRequest(ctx, method, path, body, result)
+		err := c.doRequestWithKeys(ctx, method, path, body, result)
 		latency := time.Since(startTime)
+		lastLatency = latency
+		release()
+
+		// Fail fast while the circuit of every endpoint is open
+		if _, open := err.(*CircuitOpenError); open {
+			c.logger.Debug("Circuit breaker rejected request", "path", path, "error", err)
+			return err
+		}
 
 		// Update metrics
 		c.metrics.RecordAPICall(latency, err == nil)
@@ -248,6 +537,10 @@ func (c *HTTPClient) makeRequest(ctx context.Context, method, path string, body
 
 		lastErr = err
 
+		if delay := GetRetryDelay(err); delay > 0 {
+			c.pause(time.Duration(delay) * time.Second)
+		}
+
 		// Don't retry non-retryable errors
 		if !IsRetryable(err) {
 			c.logger.Debug("Non-retryable error, not retrying", "error", err)
@@ -260,17 +553,76 @@ func (c *HTTPClient) makeRequest(ctx context.Context, method, path string, body
 	return lastErr
 }
 
-// doRequest performs the actual HTTP request
-func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body interface{}, result interface{}) error {
-	url := c.baseURL + path
+// doRequestWithFailover sends the request to the preferred healthy endpoint,
+// moving down the list when an endpoint is unreachable, returns a server error
+// or has its circuit open for the path
+func (c *HTTPClient) doRequestWithFailover(ctx context.Context, apiKey, method, path string, body interface{}, result interface{}) error {
+	var err error
+	candidates := c.endpoints.candidates()
+
+	for i, endpoint := range candidates {
+		cb := c.breaker(endpoint, path)
+		if cb != nil {
+			if err = cb.allow(); err != nil {
+				continue
+			}
+		}
+
+		err = c.doRequest(ctx, endpoint, apiKey, method, path, body, result)
+		if cb != nil {
+			cb.done(ctx, err)
+		}
+		c.metrics.RecordEndpointRequest(endpoint, err == nil)
+
+		if !isEndpointFailure(err) {
+			if c.endpoints.markHealthy(endpoint) {
+				c.metrics.RecordEndpointHealth(endpoint, true)
+				c.logger.Info("Endpoint recovered", "endpoint", endpoint)
+			}
+			return err
+		}
+
+		// The caller giving up says nothing about the endpoint
+		if ctx.Err() != nil {
+			return err
+		}
+
+		if c.endpoints.markUnhealthy(endpoint) {
+			c.metrics.RecordEndpointHealth(endpoint, false)
+			c.logger.Warn("Endpoint marked unhealthy", "endpoint", endpoint, "error", err)
+		}
+
+		if i < len(candidates)-1 {
+			c.metrics.RecordFailover()
+			c.logger.Debug("Failing over to next endpoint", "from", endpoint, "to", candidates[i+1], "path", path)
+		}
+	}
+
+	return err
+}
+
+// doRequest performs the actual HTTP request against an endpoint
+func (c *HTTPClient) doRequest(ctx context.Context, endpoint, apiKey, method, path string, body interface{}, result interface{}) error {
+	url := endpoint + path
 
 	var reqBody io.Reader
+	var payload []byte
+	compressed := false
 	if body != nil {
 		jsonData, err := json.Marshal(body)
 		if err != nil {
 			return NewValidationError("Failed to marshal request body", "", err)
 		}
-		reqBody = bytes.NewBuffer(jsonData)
+		payload = jsonData
+
+		// Compress large payloads such as batches with big attribute maps
+		if c.compression.Enabled && len(jsonData) >= c.compression.Threshold {
+			if gzipped, err := gzipBytes(jsonData); err == nil {
+				payload = gzipped
+				compressed = true
+			}
+		}
+		reqBody = bytes.NewReader(payload)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
@@ -280,26 +632,52 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", c.apiKey)
-	req.Header.Set("User-Agent", "Variably-Go-SDK/1.0.0")
+	c.setRequestHeaders(req, apiKey, payload)
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	// Setting this ourselves stops the transport from decoding responses,
+	// so that received bytes are counted before decompression. Asking for
+	// identity stops the transport from requesting gzip on its own.
+	if c.compression.DisableResponseCompression {
+		req.Header.Set("Accept-Encoding", "identity")
+	} else {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 
-	c.logger.Debug("Making HTTP request", "method", method, "url", url)
+	c.logger.Debug("Making HTTP request", "method", method, "url", url, "endpoint", endpoint, "bytes", len(payload), "compressed", compressed)
 
 	resp, err := c.client.Do(req)
 	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return NewTimeoutError("Request deadline exceeded", "", err)
+		}
 		return NewNetworkError("Request failed", 0, url, err)
 	}
 	defer resp.Body.Close()
 
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
+	c.metrics.RecordBytesTransferred(int64(len(payload)), int64(len(respBody)))
 	if err != nil {
 		return NewNetworkError("Failed to read response body", resp.StatusCode, url, err)
 	}
 
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		respBody, err = gunzipBytes(respBody)
+		if err != nil {
+			return NewNetworkError("Failed to decompress response body", resp.StatusCode, url, err)
+		}
+	}
+
+	// Slow down before the rate limit is exceeded
+	if reset := rateLimitReset(resp.Header, time.Now()); reset > 0 {
+		c.pause(reset)
+	}
+
 	// Check for HTTP errors
 	if resp.StatusCode >= 400 {
-		return c.handleHTTPError(resp.StatusCode, respBody, url)
+		return c.handleHTTPError(resp.StatusCode, resp.Header, respBody, url)
 	}
 
 	// Parse successful response
@@ -316,16 +694,25 @@ func (c *HTTPClient) doRequest(ctx context.Context, method, path string, body in
 		}
 	}
 
-	c.logger.Debug("HTTP request successful", "status", resp.StatusCode, "url", url)
+	c.logger.Debug("HTTP request successful", "status", resp.StatusCode, "url", url, "endpoint", endpoint)
 	return nil
 }
 
 // handleHTTPError converts HTTP error responses to appropriate SDK errors
-func (c *HTTPClient) handleHTTPError(statusCode int, body []byte, url string) error {
+func (c *HTTPClient) handleHTTPError(statusCode int, header http.Header, body []byte, url string) error {
+	// Retry hints from headers: Retry-After, then the rate limit reset
+	retryAfter := retryAfterSeconds(header)
+
 	var apiErr APIError
 	if err := json.Unmarshal(body, &apiErr); err != nil {
 		// If we can't parse the error, create a generic one
-		return NewNetworkError(fmt.Sprintf("HTTP %d", statusCode), statusCode, url, nil)
+		message := fmt.Sprintf("HTTP %d", statusCode)
+		if statusCode == http.StatusTooManyRequests {
+			return NewRateLimitError(message, retryAfter, nil)
+		}
+		netErr := NewNetworkError(message, statusCode, url, nil)
+		netErr.RetryAfter = retryAfter
+		return netErr
 	}
 
 	switch statusCode {
@@ -334,8 +721,7 @@ func (c *HTTPClient) handleHTTPError(statusCode int, body []byte, url string) er
 	case http.StatusBadRequest:
 		return NewValidationError(apiErr.Message, "", nil)
 	case http.StatusTooManyRequests:
-		retryAfter := 0
-		if apiErr.Details != "" {
+		if retryAfter == 0 && apiErr.Details != "" {
 			if parsed, err := strconv.Atoi(apiErr.Details); err == nil {
 				retryAfter = parsed
 			}
@@ -344,23 +730,126 @@ func (c *HTTPClient) handleHTTPError(statusCode int, body []byte, url string) er
 	case http.StatusRequestTimeout:
 		return NewTimeoutError(apiErr.Message, "", nil)
 	default:
-		return NewNetworkError(apiErr.Message, statusCode, url, nil)
+		netErr := NewNetworkError(apiErr.Message, statusCode, url, nil)
+		netErr.RetryAfter = retryAfter
+		return netErr
+	}
+}
+
+// pause stops all requests until the given delay has passed, extending any current pause
+func (c *HTTPClient) pause(delay time.Duration) {
+	until := time.Now().Add(delay)
+
+	c.pauseMutex.Lock()
+	defer c.pauseMutex.Unlock()
+
+	if until.After(c.pauseUntil) {
+		c.pauseUntil = until
+		c.logger.Warn("API requested a pause, delaying requests", "delay", delay)
 	}
 }
 
+// waitForPause blocks until the current pause, if any, is over. Pauses longer
+// than maxRetryDelay or than the time left before the context's deadline fail
+// immediately with a RateLimitError.
+func (c *HTTPClient) waitForPause(ctx context.Context) error {
+	c.pauseMutex.Lock()
+	wait := time.Until(c.pauseUntil)
+	c.pauseMutex.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	if wait > maxRetryDelay || !withinDeadline(ctx, wait) {
+		return NewRateLimitError(fmt.Sprintf("API paused requests for %s", wait.Round(time.Second)), int(math.Ceil(wait.Seconds())), nil)
+	}
+
+	c.logger.Debug("Waiting for API pause", "wait", wait)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// withinDeadline reports whether d fits in the time left before the context's deadline, if any
+func withinDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > d
+}
+
+// withOperationTimeout bounds ctx by an operation's timeout, if one is configured
+func withOperationTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// retryAfterSeconds returns the retry delay the server asked for in whole seconds,
+// from the Retry-After header or, failing that, the rate limit reset
+func retryAfterSeconds(header http.Header) int {
+	now := time.Now()
+	delay := retryAfter(header, now)
+	if delay <= 0 {
+		delay = rateLimitReset(header, now)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return int(math.Ceil(delay.Seconds()))
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an HTTP date
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// rateLimitReset returns the time until the rate limit window resets when the
+// X-RateLimit-Remaining header reports no requests left. X-RateLimit-Reset may
+// be a Unix timestamp or a number of seconds.
+func rateLimitReset(header http.Header, now time.Time) time.Duration {
+	if strings.TrimSpace(header.Get("X-RateLimit-Remaining")) != "0" {
+		return 0
+	}
+
+	reset, err := strconv.ParseInt(strings.TrimSpace(header.Get("X-RateLimit-Reset")), 10, 64)
+	if err != nil || reset <= 0 {
+		return 0
+	}
+
+	// Values this large are timestamps rather than delays
+	if reset > 1000000000 {
+		return time.Unix(reset, 0).Sub(now)
+	}
+	return time.Duration(reset) * time.Second
+}
+
 // calculateBackoff calculates exponential backoff with jitter
 func (c *HTTPClient) calculateBackoff(attempt int) time.Duration {
 	// Exponential backoff: 2^attempt * 100ms, max 30 seconds
 	base := time.Duration(100) * time.Millisecond
-	backoff := base * time.Duration(math.Pow(2, float64(attempt)))
+	// Computed in floating point so that long runs of attempts cannot overflow
+	backoff := float64(base) * math.Pow(2, float64(attempt))
+
+	// Add jitter (±25% random variation)
+	backoff += (rand.Float64()*0.5 - 0.25) * backoff
 
 	// Cap at 30 seconds
-	maxBackoff := 30 * time.Second
-	if backoff > maxBackoff {
-		backoff = maxBackoff
+	if backoff > float64(maxRetryDelay) {
+		return maxRetryDelay
 	}
-
-	// Add jitter (±25% random variation)
-	jitter := time.Duration(rand.Float64()*0.5-0.25) * backoff
-	return backoff + jitter
+	return time.Duration(backoff)
 }
\ No newline at end of file
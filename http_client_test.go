@@ -0,0 +1,796 @@
+package variably
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var healthy int32 = 1
+	var requests int32
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "blue"})
+	}, func(config *Config) {
+		config.CacheConfig.TTL = 10 * time.Millisecond
+		config.CacheConfig.StaleTTL = time.Hour
+		config.CircuitBreakerConfig.FailureThreshold = 2
+		config.CircuitBreakerConfig.ResetTimeout = 50 * time.Millisecond
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+	endpoint := server.URL + "/api/v1/sdk/evaluate"
+
+	if result := client.EvaluateFlag(ctx, "color", "red", user); result.Value != "blue" {
+		t.Fatalf("Expected blue, got %v", result.Value)
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(20 * time.Millisecond)
+
+	// Expired values are served stale while the API is failing
+	if result := client.EvaluateFlag(ctx, "color", "red", user); result.Value != "blue" || result.Reason != ReasonStale {
+		t.Errorf("Expected stale blue, got %v (%s)", result.Value, result.Reason)
+	}
+	client.EvaluateFlag(ctx, "size", "m", user)
+
+	if state := client.GetMetrics().CircuitBreakerStates[endpoint]; state != CircuitOpen {
+		t.Fatalf("Expected circuit to be open, got %q", state)
+	}
+
+	// While open, requests fail fast without reaching the API
+	before := atomic.LoadInt32(&requests)
+	result := client.EvaluateFlag(ctx, "size", "m", user)
+	var circuitErr *CircuitOpenError
+	if result.Value != "m" || !errors.As(result.Error, &circuitErr) {
+		t.Errorf("Expected default with circuit open error, got %v (%v)", result.Value, result.Error)
+	}
+	if got := atomic.LoadInt32(&requests); got != before {
+		t.Errorf("Expected no API call while open, got %d", got-before)
+	}
+
+	// A failed trial request reopens the circuit
+	time.Sleep(60 * time.Millisecond)
+	client.EvaluateFlag(ctx, "size", "m", user)
+	if state := client.GetMetrics().CircuitBreakerStates[endpoint]; state != CircuitOpen {
+		t.Errorf("Expected failed trial to reopen the circuit, got %q", state)
+	}
+
+	// A successful trial request closes it
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	if result := client.EvaluateFlag(ctx, "size", "m", user); result.Value != "blue" {
+		t.Errorf("Expected recovery, got %v (%v)", result.Value, result.Error)
+	}
+
+	metrics := client.GetMetrics()
+	if metrics.CircuitBreakerStates[endpoint] != CircuitClosed {
+		t.Errorf("Expected circuit to close, got %q", metrics.CircuitBreakerStates[endpoint])
+	}
+	if metrics.CircuitBreakerTrips != 2 {
+		t.Errorf("Expected 2 trips, got %d", metrics.CircuitBreakerTrips)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallerDeadlines(t *testing.T) {
+	client, server := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+	}, func(config *Config) {
+		config.CircuitBreakerConfig.FailureThreshold = 2
+	})
+
+	for i := 0; i < 4; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		err := client.Track(ctx, Event{Name: "click", UserID: "u1"})
+		cancel()
+		if err == nil {
+			t.Fatal("Expected the caller's deadline to expire")
+		}
+	}
+
+	if state := client.GetMetrics().CircuitBreakerStates[server.URL+"/api/v1/sdk/events"]; state == CircuitOpen {
+		t.Error("Expected short caller deadlines to leave the circuit closed")
+	}
+}
+
+func TestCircuitBreakerPerEndpoint(t *testing.T) {
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "secondary"})
+	}))
+	defer secondary.Close()
+
+	var primaryURL string
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, func(config *Config) {
+		primaryURL = config.BaseURL
+		config.Endpoints = []string{config.BaseURL, secondary.URL}
+		config.CircuitBreakerConfig.FailureThreshold = 1
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+	for _, flagKey := range []string{"a", "b"} {
+		if result := client.EvaluateFlag(ctx, flagKey, "default", user); result.Value != "secondary" {
+			t.Errorf("Expected the secondary to serve %s, got %v (%v)", flagKey, result.Value, result.Error)
+		}
+	}
+
+	// The primary's open circuit does not carry over to the secondary
+	states := client.GetMetrics().CircuitBreakerStates
+	if state := states[primaryURL+"/api/v1/sdk/evaluate"]; state != CircuitOpen {
+		t.Errorf("Expected the primary's circuit to be open, got %q", state)
+	}
+	if state := states[secondary.URL+"/api/v1/sdk/evaluate"]; state != CircuitClosed && state != "" {
+		t.Errorf("Expected the secondary's circuit to stay closed, got %q", state)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	client := &HTTPClient{}
+
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		delay := client.calculateBackoff(3)
+		if delay < 600*time.Millisecond || delay > time.Second {
+			t.Errorf("Expected 800ms ±25%%, got %s", delay)
+		}
+		delays[delay] = true
+	}
+	if len(delays) < 2 {
+		t.Error("Expected jittered delays to vary")
+	}
+
+	// Long outages stay at the cap instead of overflowing
+	for _, attempt := range []int{20, 40, 100} {
+		if delay := client.calculateBackoff(attempt); delay <= 0 || delay > maxRetryDelay {
+			t.Errorf("Expected attempt %d to wait up to %s, got %s", attempt, maxRetryDelay, delay)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Run("waits before retrying", func(t *testing.T) {
+		var requests int32
+		client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+		}, func(config *Config) {
+			config.RetryAttempts = 1
+		})
+
+		start := time.Now()
+		result := client.EvaluateFlag(context.Background(), "feature", false, UserContext{UserID: "u1"})
+		if result.Value != true {
+			t.Fatalf("Expected retry to succeed, got %v (%v)", result.Value, result.Error)
+		}
+		if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+			t.Errorf("Expected to wait for Retry-After, retried after %s", elapsed)
+		}
+	})
+
+	t.Run("pauses all requests", func(t *testing.T) {
+		var requests int32
+		client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, func(config *Config) {
+			config.RetryAttempts = 3
+		})
+
+		ctx := context.Background()
+		first := client.EvaluateFlag(ctx, "a", false, UserContext{UserID: "u1"})
+		second := client.EvaluateFlag(ctx, "b", false, UserContext{UserID: "u2"})
+
+		var netErr *NetworkError
+		if !errors.As(first.Error, &netErr) || netErr.RetryAfter < 59 {
+			t.Errorf("Expected 503 with Retry-After, got %v", first.Error)
+		}
+		var rateErr *RateLimitError
+		if !errors.As(second.Error, &rateErr) {
+			t.Errorf("Expected second request to fail fast while paused, got %v", second.Error)
+		}
+		if got := atomic.LoadInt32(&requests); got != 1 {
+			t.Errorf("Expected a single request during the pause, got %d", got)
+		}
+	})
+}
+
+func TestRetryAfterHeaders(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	header := func(pairs ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Set(pairs[i], pairs[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		parse  func(http.Header, time.Time) time.Duration
+		want   time.Duration
+	}{
+		{"seconds", header("Retry-After", "120"), retryAfter, 2 * time.Minute},
+		{"http date", header("Retry-After", now.Add(90*time.Second).Format(http.TimeFormat)), retryAfter, 90 * time.Second},
+		{"invalid", header("Retry-After", "soon"), retryAfter, 0},
+		{"reset delay", header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", "30"), rateLimitReset, 30 * time.Second},
+		{"reset timestamp", header("X-RateLimit-Remaining", "0", "X-RateLimit-Reset", strconv.FormatInt(now.Add(time.Minute).Unix(), 10)), rateLimitReset, time.Minute},
+		{"requests remaining", header("X-RateLimit-Remaining", "10", "X-RateLimit-Reset", "30"), rateLimitReset, 0},
+	}
+
+	for _, tt := range tests {
+		if got := tt.parse(tt.header, now); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestCustomTransport(t *testing.T) {
+	var wrapped int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Egress") != "gateway" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+	}, func(config *Config) {
+		config.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&wrapped, 1)
+			r.Header.Set("X-Egress", "gateway")
+			return http.DefaultTransport.RoundTrip(r)
+		})
+	})
+
+	result := client.EvaluateFlag(context.Background(), "feature", false, UserContext{UserID: "u1"})
+	if result.Value != true {
+		t.Fatalf("Expected request through custom transport, got %v (%v)", result.Value, result.Error)
+	}
+	if atomic.LoadInt32(&wrapped) != 1 {
+		t.Errorf("Expected 1 request through custom transport, got %d", wrapped)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Proxied requests carry the absolute target URL and the proxy credentials
+		if r.URL.Host != "variably.internal" || r.Header.Get("Proxy-Authorization") == "" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		atomic.AddInt32(&proxied, 1)
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+	}))
+	defer proxy.Close()
+
+	config := DefaultConfig()
+	config.APIKey = "test-key"
+	config.BaseURL = "http://variably.internal"
+	config.ProxyURL = "http://sdk:secret@" + proxy.Listener.Addr().String()
+	config.RetryAttempts = 0
+	config.Logger = NewNoOpLogger()
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	result := client.EvaluateFlag(context.Background(), "feature", false, UserContext{UserID: "u1"})
+	if result.Value != true || atomic.LoadInt32(&proxied) != 1 {
+		t.Errorf("Expected request through the proxy, got %v (%v)", result.Value, result.Error)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := newTestCertificate(t, nil, nil, "Test CA")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	server.TLS.ClientCAs.AddCert(ca)
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	// Trust the test server's certificate through the CA bundle
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	cert, key := newTestCertificate(t, ca, caKey, "sdk-client")
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", cert.Raw)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	newClient := func(tlsConfig TLSConfig) Client {
+		config := DefaultConfig()
+		config.APIKey = "test-key"
+		config.BaseURL = server.URL
+		config.RetryAttempts = 0
+		config.Logger = NewNoOpLogger()
+		config.TLSConfig = tlsConfig
+		client, err := NewClient(config)
+		if err != nil {
+			t.Fatalf("Failed to create client: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	client := newClient(TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2"})
+	if result := client.EvaluateFlag(ctx, "feature", false, user); result.Value != true {
+		t.Errorf("Expected mTLS request to succeed, got %v (%v)", result.Value, result.Error)
+	}
+
+	client = newClient(TLSConfig{CAFile: caFile})
+	if result := client.EvaluateFlag(ctx, "feature", false, user); result.Error == nil {
+		t.Error("Expected request without client certificate to fail")
+	}
+
+	invalid := []TLSConfig{
+		{CAFile: filepath.Join(dir, "missing.pem")},
+		{CertFile: certFile},
+		{MinVersion: "1.4"},
+	}
+	for _, tlsConfig := range invalid {
+		config := DefaultConfig()
+		config.APIKey = "test-key"
+		config.TLSConfig = tlsConfig
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", tlsConfig)
+		}
+	}
+}
+
+// newTestCertificate creates a certificate signed by parent, or a self-signed CA if parent is nil
+func newTestCertificate(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEndpointFailover(t *testing.T) {
+	var primaryDown int32 = 1
+	var primaryRequests, secondaryRequests int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondaryRequests, 1)
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "secondary"})
+	}))
+	defer secondary.Close()
+
+	var primaryURL string
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&primaryDown) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.URL.Path == healthPath {
+			return
+		}
+		atomic.AddInt32(&primaryRequests, 1)
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "primary"})
+	}, func(config *Config) {
+		primaryURL = config.BaseURL
+		config.Endpoints = []string{config.BaseURL, secondary.URL}
+		config.FailoverConfig.ProbeInterval = 20 * time.Millisecond
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	if result := client.EvaluateFlag(ctx, "a", "default", user); result.Value != "secondary" {
+		t.Fatalf("Expected failover to secondary, got %v (%v)", result.Value, result.Error)
+	}
+
+	// Unhealthy endpoints are skipped until they recover
+	client.EvaluateFlag(ctx, "b", "default", user)
+	if got := atomic.LoadInt32(&secondaryRequests); got != 2 {
+		t.Errorf("Expected 2 requests to secondary, got %d", got)
+	}
+
+	metrics := client.GetMetrics()
+	if metrics.Failovers != 1 {
+		t.Errorf("Expected 1 failover, got %d", metrics.Failovers)
+	}
+	if stats := metrics.Endpoints[primaryURL]; stats.Healthy || stats.Errors != 1 {
+		t.Errorf("Expected primary to be unhealthy with 1 error, got %+v", stats)
+	}
+	if state := metrics.CircuitBreakerStates[secondary.URL+"/api/v1/sdk/evaluate"]; state == CircuitOpen {
+		t.Error("Expected the secondary's circuit to stay closed")
+	}
+
+	// Probes fail back to the primary once it recovers
+	atomic.StoreInt32(&primaryDown, 0)
+	time.Sleep(100 * time.Millisecond)
+	if result := client.EvaluateFlag(ctx, "c", "default", user); result.Value != "primary" {
+		t.Errorf("Expected fail back to primary, got %v (%v)", result.Value, result.Error)
+	}
+	if stats := client.GetMetrics().Endpoints[primaryURL]; !stats.Healthy || stats.Requests != 2 {
+		t.Errorf("Expected healthy primary with 2 requests, got %+v", stats)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	var compressedRequests int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			atomic.AddInt32(&compressedRequests, 1)
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = reader
+		}
+
+		var req BatchEvaluateFlagsRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		results := make(map[string]EvaluateFlagResponse)
+		for _, key := range req.FlagKeys {
+			results[key] = EvaluateFlagResponse{FlagKey: key, Value: req.Context.Attributes["plan"]}
+		}
+
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			json.NewEncoder(w).Encode(BatchEvaluateFlagsResponse{Results: results})
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		json.NewEncoder(writer).Encode(BatchEvaluateFlagsResponse{Results: results})
+		writer.Close()
+	}
+	client, _ := newTestClient(t, handler, func(config *Config) {
+		config.CompressionConfig = CompressionConfig{Enabled: true, Threshold: 512}
+	})
+
+	ctx := context.Background()
+	attributes := map[string]interface{}{"plan": "enterprise"}
+	for i := 0; i < 100; i++ {
+		attributes["attribute_"+strconv.Itoa(i)] = "a fairly repetitive attribute value"
+	}
+
+	results := client.EvaluateFlags(ctx, []string{"a", "b"}, UserContext{UserID: "u1", Attributes: attributes})
+	if results["a"].Value != "enterprise" || results["b"].Value != "enterprise" {
+		t.Fatalf("Expected compressed round trip, got %+v", results)
+	}
+
+	small := client.EvaluateFlags(ctx, []string{"c"}, UserContext{UserID: "u2", Attributes: map[string]interface{}{"plan": "free"}})
+	if small["c"].Value != "free" {
+		t.Fatalf("Expected small request to succeed, got %+v", small["c"])
+	}
+	if got := atomic.LoadInt32(&compressedRequests); got != 1 {
+		t.Errorf("Expected only the large request to be compressed, got %d", got)
+	}
+
+	uncompressed, _ := json.Marshal(BatchEvaluateFlagsRequest{FlagKeys: []string{"a", "b"}, Context: UserContext{UserID: "u1", Attributes: attributes}})
+	metrics := client.GetMetrics()
+	if metrics.BytesSent == 0 || metrics.BytesSent >= int64(len(uncompressed)) {
+		t.Errorf("Expected compressed bytes sent below %d, got %d", len(uncompressed), metrics.BytesSent)
+	}
+	if metrics.BytesReceived == 0 {
+		t.Error("Expected bytes received to be recorded")
+	}
+
+	// Request compression is opt-in, since the API must accept gzip bodies
+	defaults, _ := newTestClient(t, handler, nil)
+	results = defaults.EvaluateFlags(ctx, []string{"a"}, UserContext{UserID: "u1", Attributes: attributes})
+	if results["a"].Value != "enterprise" {
+		t.Fatalf("Expected uncompressed round trip, got %+v", results["a"])
+	}
+	if got := atomic.LoadInt32(&compressedRequests); got != 1 {
+		t.Errorf("Expected requests to be uncompressed by default, got %d compressed", got)
+	}
+
+	// Response compression can be turned off
+	var encodings []string
+	identity, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		encodings = append(encodings, r.Header.Get("Accept-Encoding"))
+		handler(w, r)
+	}, func(config *Config) {
+		config.CompressionConfig.DisableResponseCompression = true
+	})
+	results = identity.EvaluateFlags(ctx, []string{"a"}, UserContext{UserID: "u1", Attributes: attributes})
+	if results["a"].Value != "enterprise" || len(encodings) != 1 || encodings[0] != "identity" {
+		t.Errorf("Expected an uncompressed response, got %+v with Accept-Encoding %v", results["a"], encodings)
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "cached"})
+			return
+		}
+		<-release
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "live"})
+	}, func(config *Config) {
+		config.CacheConfig.TTL = 10 * time.Millisecond
+		config.RetryAttempts = 2
+		config.RateLimitConfig = RateLimitConfig{
+			Enabled:           true,
+			RequestsPerSecond: 1,
+			Burst:             3,
+			MaxConcurrent:     1,
+			MaxWait:           20 * time.Millisecond,
+		}
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+	client.EvaluateFlag(ctx, "cached", "default", user)
+	time.Sleep(20 * time.Millisecond)
+
+	// Hold the only in-flight slot
+	go client.EvaluateFlag(ctx, "slow", "default", user)
+	time.Sleep(20 * time.Millisecond)
+
+	// Expired values are served when the limit is reached, otherwise the default
+	result := client.EvaluateFlag(ctx, "cached", "default", user)
+	if result.Value != "cached" || result.Reason != ReasonRateLimited {
+		t.Errorf("Expected cached value with reason RATE_LIMITED, got %v (%s)", result.Value, result.Reason)
+	}
+	result = client.EvaluateFlag(ctx, "other", "default", user)
+	if result.Value != "default" || result.Reason != ReasonRateLimited || !IsClientRateLimited(result.Error) {
+		t.Errorf("Expected default with reason RATE_LIMITED, got %v (%s, %v)", result.Value, result.Reason, result.Error)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("Expected rejected requests not to reach the API, got %d requests", got)
+	}
+
+	// Requests turned away for want of a slot gave their tokens back
+	close(release)
+	time.Sleep(20 * time.Millisecond)
+	if result := client.EvaluateFlag(ctx, "another", "default", user); result.Value != "live" {
+		t.Errorf("Expected the last token to be available, got %v (%s, %v)", result.Value, result.Reason, result.Error)
+	}
+
+	// The bucket is now empty and the next token is a second away
+	start := time.Now()
+	client.EvaluateFlag(ctx, "last", "default", user)
+	if elapsed := time.Since(start); elapsed > 15*time.Millisecond {
+		t.Errorf("Expected rate limited request to fail fast, took %s", elapsed)
+	}
+	if got := client.GetMetrics().RateLimitedRequests; got != 3 {
+		t.Errorf("Expected 3 rate limited requests, got %d", got)
+	}
+}
+
+func TestRequestSigning(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodyHash := sha256.Sum256(body)
+		timestamp := r.Header.Get("X-Variably-Timestamp")
+
+		mac := hmac.New(sha256.New, []byte("signing-secret"))
+		mac.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n" + timestamp + "\n" + hex.EncodeToString(bodyHash[:])))
+		expected := hex.EncodeToString(mac.Sum(nil))
+
+		signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(signedAt, 0)) > time.Minute || !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Variably-Signature"))) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+	}, func(config *Config) {
+		config.SigningConfig = SigningConfig{Enabled: true, Secret: "signing-secret"}
+	})
+
+	result := client.EvaluateFlag(context.Background(), "feature", false, UserContext{UserID: "u1"})
+	if result.Value != true {
+		t.Errorf("Expected signed request to be accepted, got %v (%v)", result.Value, result.Error)
+	}
+
+	// The API key is sent with every request, so it must not double as the secret
+	for _, secret := range []string{"", "test-key"} {
+		config := DefaultConfig()
+		config.APIKey = "test-key"
+		config.SigningConfig = SigningConfig{Enabled: true, Secret: secret}
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected signing secret %q to be rejected", secret)
+		}
+	}
+}
+
+func TestAPIKeyRotation(t *testing.T) {
+	var mutex sync.Mutex
+	accepted := map[string]bool{"old-key": true}
+	var seen []string
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-API-Key")
+		mutex.Lock()
+		seen = append(seen, key)
+		ok := accepted[key]
+		mutex.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(APIError{Code: "UNAUTHORIZED", Message: "invalid API key"})
+			return
+		}
+		json.NewEncoder(w).Encode(EvaluateFlagResponse{Enabled: true})
+	}, func(config *Config) {
+		config.APIKey = "old-key"
+		config.CacheConfig.TTL = time.Nanosecond
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+	evaluate := func() []string {
+		mutex.Lock()
+		seen = nil
+		mutex.Unlock()
+		client.EvaluateFlag(ctx, "feature", false, user)
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), seen...)
+	}
+
+	if err := client.RotateAPIKey(""); err == nil {
+		t.Error("Expected empty key to be rejected")
+	}
+	if err := client.RotateAPIKey("new-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key has not propagated yet, so the old key is used as a fallback
+	if keys := evaluate(); len(keys) != 2 || keys[0] != "new-key" || keys[1] != "old-key" {
+		t.Errorf("Expected new key then old key, got %v", keys)
+	}
+
+	// Once the new key is accepted the old key is retired
+	mutex.Lock()
+	accepted["new-key"] = true
+	mutex.Unlock()
+	if keys := evaluate(); len(keys) != 1 || keys[0] != "new-key" {
+		t.Errorf("Expected only the new key, got %v", keys)
+	}
+	mutex.Lock()
+	delete(accepted, "new-key")
+	mutex.Unlock()
+
+	if keys := evaluate(); len(keys) != 1 || keys[0] != "new-key" {
+		t.Errorf("Expected the retired key not to be used, got %v", keys)
+	}
+}
+
+func TestDeadlineBudget(t *testing.T) {
+	var requests int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(30 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, func(config *Config) {
+		config.RetryAttempts = 5
+		config.CircuitBreakerConfig.Enabled = false
+		config.TimeoutConfig.Batch = 50 * time.Millisecond
+	})
+
+	user := UserContext{UserID: "u1"}
+
+	t.Run("caller deadline", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		result := client.EvaluateFlag(ctx, "feature", false, user)
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("Expected the 50ms budget to be honoured, took %s", elapsed)
+		}
+		if result.Error == nil {
+			t.Error("Expected an error")
+		}
+		if got := atomic.LoadInt32(&requests); got != 1 {
+			t.Errorf("Expected retries that cannot finish in time to be skipped, got %d requests", got)
+		}
+	})
+
+	t.Run("operation timeout", func(t *testing.T) {
+		atomic.StoreInt32(&requests, 0)
+		start := time.Now()
+		client.EvaluateFlags(context.Background(), []string{"a", "b"}, user)
+		if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+			t.Errorf("Expected the batch timeout to be honoured, took %s", elapsed)
+		}
+		if got := atomic.LoadInt32(&requests); got != 1 {
+			t.Errorf("Expected 1 request, got %d", got)
+		}
+	})
+}
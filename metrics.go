@@ -27,12 +27,37 @@ type MetricsCollector struct {
 	lastErrorRate    float64
 	lastCacheHitRate float64
 	rateMutex        sync.RWMutex
+
+	// Circuit breaker tracking
+	circuitTrips  int64
+	circuitStates map[string]CircuitState
+	circuitMutex  sync.RWMutex
+
+	// Endpoint tracking
+	failovers     int64
+	endpointStats map[string]EndpointStats
+	endpointMutex sync.RWMutex
+
+	// Bytes on the wire, after compression
+	bytesSent     int64
+	bytesReceived int64
+
+	// Requests rejected by the client-side rate limiter
+	rateLimited int64
+
+	// Subscribers currently registered
+	activeSubscribers int64
+
+	// Events dropped before being sent
+	eventsDropped int64
 }
 
 // NewMetricsCollector creates a new metrics collector
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
-		startTime: time.Now(),
+		startTime:     time.Now(),
+		circuitStates: make(map[string]CircuitState),
+		endpointStats: make(map[string]EndpointStats),
 	}
 }
 
@@ -74,6 +99,74 @@ func (m *MetricsCollector) RecordEventTracked() {
 	atomic.AddInt64(&m.eventsTracked, 1)
 }
 
+// RecordCircuitStateChange records a circuit breaker moving to a new state
+func (m *MetricsCollector) RecordCircuitStateChange(endpoint string, state CircuitState) {
+	if state == CircuitOpen {
+		atomic.AddInt64(&m.circuitTrips, 1)
+	}
+
+	m.circuitMutex.Lock()
+	m.circuitStates[endpoint] = state
+	m.circuitMutex.Unlock()
+}
+
+// RecordEndpointRequest records a request served, or failed, by an endpoint
+func (m *MetricsCollector) RecordEndpointRequest(endpoint string, success bool) {
+	m.endpointMutex.Lock()
+	defer m.endpointMutex.Unlock()
+
+	stats, exists := m.endpointStats[endpoint]
+	if !exists {
+		stats.Healthy = true
+	}
+	stats.Requests++
+	if !success {
+		stats.Errors++
+	}
+	m.endpointStats[endpoint] = stats
+}
+
+// RecordEndpointHealth records an endpoint becoming healthy or unhealthy
+func (m *MetricsCollector) RecordEndpointHealth(endpoint string, healthy bool) {
+	m.endpointMutex.Lock()
+	defer m.endpointMutex.Unlock()
+
+	stats := m.endpointStats[endpoint]
+	stats.Healthy = healthy
+	m.endpointStats[endpoint] = stats
+}
+
+// RecordFailover records a request moving on to the next endpoint
+func (m *MetricsCollector) RecordFailover() {
+	atomic.AddInt64(&m.failovers, 1)
+}
+
+// RecordBytesTransferred records the request and response body sizes of an API call
+func (m *MetricsCollector) RecordBytesTransferred(sent, received int64) {
+	atomic.AddInt64(&m.bytesSent, sent)
+	atomic.AddInt64(&m.bytesReceived, received)
+}
+
+// RecordRateLimited records a request rejected by the client-side rate limiter
+func (m *MetricsCollector) RecordRateLimited() {
+	atomic.AddInt64(&m.rateLimited, 1)
+}
+
+// RecordEventDropped records an event dropped before being sent
+func (m *MetricsCollector) RecordEventDropped() {
+	atomic.AddInt64(&m.eventsDropped, 1)
+}
+
+// RecordSubscribed records a subscriber being registered
+func (m *MetricsCollector) RecordSubscribed() {
+	atomic.AddInt64(&m.activeSubscribers, 1)
+}
+
+// RecordUnsubscribed records a subscriber being removed
+func (m *MetricsCollector) RecordUnsubscribed() {
+	atomic.AddInt64(&m.activeSubscribers, -1)
+}
+
 // GetMetrics returns current metrics snapshot
 func (m *MetricsCollector) GetMetrics() Metrics {
 	apiCalls := atomic.LoadInt64(&m.apiCalls)
@@ -83,6 +176,21 @@ func (m *MetricsCollector) GetMetrics() Metrics {
 	flagsEvaluated := atomic.LoadInt64(&m.flagsEvaluated)
 	gatesEvaluated := atomic.LoadInt64(&m.gatesEvaluated)
 	eventsTracked := atomic.LoadInt64(&m.eventsTracked)
+	circuitTrips := atomic.LoadInt64(&m.circuitTrips)
+	
+	m.circuitMutex.RLock()
+	circuitStates := make(map[string]CircuitState, len(m.circuitStates))
+	for endpoint, state := range m.circuitStates {
+		circuitStates[endpoint] = state
+	}
+	m.circuitMutex.RUnlock()
+
+	m.endpointMutex.RLock()
+	endpointStats := make(map[string]EndpointStats, len(m.endpointStats))
+	for endpoint, stats := range m.endpointStats {
+		endpointStats[endpoint] = stats
+	}
+	m.endpointMutex.RUnlock()
 	
 	m.latencyMutex.RLock()
 	totalLatency := m.totalLatency
@@ -124,6 +232,21 @@ func (m *MetricsCollector) GetMetrics() Metrics {
 		FlagsEvaluated:  flagsEvaluated,
 		GatesEvaluated:  gatesEvaluated,
 		EventsTracked:   eventsTracked,
+
+		CircuitBreakerTrips:  circuitTrips,
+		CircuitBreakerStates: circuitStates,
+
+		Failovers: atomic.LoadInt64(&m.failovers),
+		Endpoints: endpointStats,
+
+		BytesSent:     atomic.LoadInt64(&m.bytesSent),
+		BytesReceived: atomic.LoadInt64(&m.bytesReceived),
+
+		RateLimitedRequests: atomic.LoadInt64(&m.rateLimited),
+
+		ActiveSubscribers: atomic.LoadInt64(&m.activeSubscribers),
+
+		EventsDropped: atomic.LoadInt64(&m.eventsDropped),
 	}
 }
 
@@ -136,6 +259,18 @@ func (m *MetricsCollector) Reset() {
 	atomic.StoreInt64(&m.flagsEvaluated, 0)
 	atomic.StoreInt64(&m.gatesEvaluated, 0)
 	atomic.StoreInt64(&m.eventsTracked, 0)
+	atomic.StoreInt64(&m.circuitTrips, 0)
+	atomic.StoreInt64(&m.failovers, 0)
+	atomic.StoreInt64(&m.bytesSent, 0)
+	atomic.StoreInt64(&m.bytesReceived, 0)
+	atomic.StoreInt64(&m.rateLimited, 0)
+	atomic.StoreInt64(&m.eventsDropped, 0)
+
+	m.endpointMutex.Lock()
+	for endpoint, stats := range m.endpointStats {
+		m.endpointStats[endpoint] = EndpointStats{Healthy: stats.Healthy}
+	}
+	m.endpointMutex.Unlock()
 	
 	m.latencyMutex.Lock()
 	m.totalLatency = 0
@@ -180,5 +315,14 @@ func (m *MetricsCollector) Summary() map[string]interface{} {
 		"error_rate":       metrics.ErrorRate,
 		"average_latency":  metrics.AverageLatency.String(),
 		"total_latency":    metrics.TotalLatency.String(),
+		"circuit_trips":    metrics.CircuitBreakerTrips,
+		"circuit_states":   metrics.CircuitBreakerStates,
+		"failovers":        metrics.Failovers,
+		"endpoints":        metrics.Endpoints,
+		"bytes_sent":       metrics.BytesSent,
+		"bytes_received":   metrics.BytesReceived,
+		"rate_limited":     metrics.RateLimitedRequests,
+		"subscribers":      metrics.ActiveSubscribers,
+		"events_dropped":   metrics.EventsDropped,
 	}
 }
\ No newline at end of file
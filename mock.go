@@ -2,6 +2,7 @@ package variably
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -10,7 +11,12 @@ import (
 type MockClient struct {
 	flagValues    map[string]interface{}
 	gateValues    map[string]bool
+	experiments   map[string]ExperimentAssignment
+	segments      map[string]bool
 	trackedEvents []Event
+	apiKey        string
+	strictFlags   bool
+	watchers      map[string]map[chan struct{}]struct{}
 	metrics       *MetricsCollector
 	mutex         sync.RWMutex
 }
@@ -20,7 +26,10 @@ func NewMockClient() *MockClient {
 	return &MockClient{
 		flagValues:    make(map[string]interface{}),
 		gateValues:    make(map[string]bool),
+		experiments:   make(map[string]ExperimentAssignment),
+		segments:      make(map[string]bool),
 		trackedEvents: make([]Event, 0),
+		watchers:      make(map[string]map[chan struct{}]struct{}),
 		metrics:       NewMetricsCollector(),
 	}
 }
@@ -30,6 +39,19 @@ func (m *MockClient) SetFlagValue(flagKey string, value interface{}) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.flagValues[flagKey] = value
+
+	for changed := range m.watchers[flagKey] {
+		signal(changed)
+	}
+}
+
+// SetStrictFlags makes evaluations of flags without a mock value fail with
+// ErrorCodeFlagNotFound, as the client does for flags that do not exist.
+// By default they return the default value with ReasonDefault and no error.
+func (m *MockClient) SetStrictFlags(strict bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.strictFlags = strict
 }
 
 // SetGateValue sets a mock gate value
@@ -39,6 +61,21 @@ func (m *MockClient) SetGateValue(gateKey string, value bool) {
 	m.gateValues[gateKey] = value
 }
 
+// SetExperimentAssignment sets a mock experiment assignment
+func (m *MockClient) SetExperimentAssignment(experimentKey string, assignment ExperimentAssignment) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	assignment.ExperimentKey = experimentKey
+	m.experiments[experimentKey] = assignment
+}
+
+// SetSegmentMembership sets whether users are mock members of a segment
+func (m *MockClient) SetSegmentMembership(segmentKey string, member bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.segments[segmentKey] = member
+}
+
 // GetTrackedEvents returns all tracked events
 func (m *MockClient) GetTrackedEvents() []Event {
 	m.mutex.RLock()
@@ -56,6 +93,13 @@ func (m *MockClient) ClearTrackedEvents() {
 	m.trackedEvents = make([]Event, 0)
 }
 
+// GetAPIKey returns the key most recently passed to RotateAPIKey
+func (m *MockClient) GetAPIKey() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.apiKey
+}
+
 // Reset resets all mock data
 func (m *MockClient) Reset() {
 	m.mutex.Lock()
@@ -63,8 +107,16 @@ func (m *MockClient) Reset() {
 	
 	m.flagValues = make(map[string]interface{})
 	m.gateValues = make(map[string]bool)
+	m.experiments = make(map[string]ExperimentAssignment)
+	m.segments = make(map[string]bool)
 	m.trackedEvents = make([]Event, 0)
 	m.metrics.Reset()
+
+	for _, watchers := range m.watchers {
+		for changed := range watchers {
+			signal(changed)
+		}
+	}
 }
 
 // Client interface implementation
@@ -79,16 +131,28 @@ func (m *MockClient) EvaluateFlag(ctx context.Context, flagKey string, defaultVa
 		return FlagResult{
 			Key:         flagKey,
 			Value:       value,
-			Reason:      "mock_rule",
+			Reason:      ReasonTargetingMatch,
 			EvaluatedAt: time.Now(),
 			CacheHit:    false,
 		}
 	}
 	
+	if m.strictFlags {
+		return FlagResult{
+			Key:         flagKey,
+			Value:       defaultValue,
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeFlagNotFound,
+			Error:       fmt.Errorf("flag %q has no mock value", flagKey),
+			EvaluatedAt: time.Now(),
+			CacheHit:    false,
+		}
+	}
+
 	return FlagResult{
 		Key:         flagKey,
 		Value:       defaultValue,
-		Reason:      "default",
+		Reason:      ReasonDefault,
 		EvaluatedAt: time.Now(),
 		CacheHit:    false,
 	}
@@ -156,6 +220,38 @@ func (m *MockClient) EvaluateGate(ctx context.Context, gateKey string, userConte
 	return false // Default for gates
 }
 
+func (m *MockClient) GetExperimentAssignment(ctx context.Context, experimentKey string, userContext UserContext) (ExperimentAssignment, error) {
+	m.mutex.RLock()
+	assignment, exists := m.experiments[experimentKey]
+	m.mutex.RUnlock()
+	
+	if !exists {
+		return ExperimentAssignment{ExperimentKey: experimentKey}, nil
+	}
+	
+	assignment.AssignedAt = time.Now()
+	if assignment.InExperiment {
+		m.Track(ctx, Event{
+			Name:      ExposureEventName,
+			UserID:    userContext.UserID,
+			SessionID: userContext.SessionID,
+			Properties: map[string]interface{}{
+				"experiment_key": experimentKey,
+				"variant":        assignment.Variant,
+			},
+			Context: userContext,
+		})
+	}
+	return assignment, nil
+}
+
+func (m *MockClient) IsInSegment(ctx context.Context, segmentKey string, userContext UserContext) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	
+	return m.segments[segmentKey]
+}
+
 func (m *MockClient) EvaluateFlags(ctx context.Context, flagKeys []string, userContext UserContext) map[string]FlagResult {
 	results := make(map[string]FlagResult)
 	for _, flagKey := range flagKeys {
@@ -172,6 +268,26 @@ func (m *MockClient) EvaluateGates(ctx context.Context, gateKeys []string, userC
 	return results
 }
 
+func (m *MockClient) AllFlags(ctx context.Context, userContext UserContext) (FlagsState, error) {
+	m.mutex.RLock()
+	flagKeys := make([]string, 0, len(m.flagValues))
+	for flagKey := range m.flagValues {
+		flagKeys = append(flagKeys, flagKey)
+	}
+	gateKeys := make([]string, 0, len(m.gateValues))
+	for gateKey := range m.gateValues {
+		gateKeys = append(gateKeys, gateKey)
+	}
+	m.mutex.RUnlock()
+	
+	return FlagsState{
+		Flags:       m.EvaluateFlags(ctx, flagKeys, userContext),
+		Gates:       m.EvaluateGates(ctx, gateKeys, userContext),
+		EvaluatedAt: time.Now(),
+		Valid:       true,
+	}, nil
+}
+
 func (m *MockClient) Track(ctx context.Context, event Event) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -194,9 +310,12 @@ func (m *MockClient) TrackBatch(ctx context.Context, events []Event) error {
 	return nil
 }
 
-func (m *MockClient) Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) error {
+func (m *MockClient) Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) (*Subscription, error) {
+	if len(flagKeys) == 0 {
+		return nil, NewValidationError("At least one flag key or pattern is required", "flag_keys", nil)
+	}
 	// Mock implementation - no actual subscription
-	return nil
+	return &Subscription{}, nil
 }
 
 func (m *MockClient) Unsubscribe(flagKeys []string) error {
@@ -204,6 +323,33 @@ func (m *MockClient) Unsubscribe(flagKeys []string) error {
 	return nil
 }
 
+// Watch emits the flag's current value, then again each time SetFlagValue or
+// Reset changes it, until ctx is cancelled
+func (m *MockClient) Watch(ctx context.Context, flagKey string, userContext UserContext) <-chan FlagResult {
+	changed := make(chan struct{}, 1)
+	m.mutex.Lock()
+	if m.watchers[flagKey] == nil {
+		m.watchers[flagKey] = make(map[chan struct{}]struct{})
+	}
+	m.watchers[flagKey][changed] = struct{}{}
+	m.mutex.Unlock()
+
+	results := make(chan FlagResult, 1)
+	go func() {
+		defer func() {
+			m.mutex.Lock()
+			delete(m.watchers[flagKey], changed)
+			m.mutex.Unlock()
+		}()
+
+		watchFlag(ctx, nil, changed, func() FlagResult {
+			return m.EvaluateFlag(ctx, flagKey, nil, userContext)
+		}, results)
+	}()
+
+	return results
+}
+
 func (m *MockClient) RefreshCache(ctx context.Context) error {
 	// Mock implementation - no actual cache
 	return nil
@@ -214,6 +360,17 @@ func (m *MockClient) ClearCache() error {
 	return nil
 }
 
+func (m *MockClient) RotateAPIKey(newKey string) error {
+	if newKey == "" {
+		return NewValidationError("API key must not be empty", "api_key", nil)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.apiKey = newKey
+	return nil
+}
+
 func (m *MockClient) GetMetrics() Metrics {
 	return m.metrics.GetMetrics()
 }
@@ -0,0 +1,110 @@
+package variably
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter caps outbound API calls with a token bucket and a limit on
+// requests in flight. Callers wait at most maxWait for a token and a slot,
+// so a burst of cache misses fails fast instead of queueing indefinitely.
+type rateLimiter struct {
+	rate    float64 // tokens per second
+	burst   float64
+	maxWait time.Duration
+	slots   chan struct{}
+
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter with a full bucket
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{
+		rate:    config.RequestsPerSecond,
+		burst:   float64(config.Burst),
+		maxWait: config.MaxWait,
+		slots:   make(chan struct{}, config.MaxConcurrent),
+		tokens:  float64(config.Burst),
+		last:    time.Now(),
+	}
+}
+
+// acquire waits for a token and an in-flight slot. The returned function
+// releases the slot and must be called once the request completes. If no
+// request is made, the token is given back.
+func (l *rateLimiter) acquire(ctx context.Context) (func(), error) {
+	deadline := time.Now().Add(l.maxWait)
+
+	if wait, ok := l.reserve(); !ok {
+		return nil, NewClientRateLimitError(fmt.Sprintf("request rate limit of %g per second exceeded", l.rate))
+	} else if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			l.unreserve()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		l.unreserve()
+		return nil, ctx.Err()
+	case <-timer.C:
+		l.unreserve()
+		return nil, NewClientRateLimitError(fmt.Sprintf("%d requests already in flight", cap(l.slots)))
+	}
+}
+
+// reserve takes a token, returning how long to wait until it is available.
+// It fails without taking a token if the wait would exceed maxWait.
+func (l *rateLimiter) reserve() (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	var wait time.Duration
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		if wait > l.maxWait {
+			return 0, false
+		}
+	}
+
+	l.tokens--
+	return wait, true
+}
+
+// unreserve gives back a token taken by reserve for a request that was not made
+func (l *rateLimiter) unreserve() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens++
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
@@ -0,0 +1,252 @@
+package variably
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+)
+
+// streamStableAfter is how long a real-time connection must stay up before
+// reconnection backoff starts over, so that a server which accepts
+// connections and then drops them is not reconnected to in a tight loop
+const streamStableAfter = 30 * time.Second
+
+// FlagUpdateMessage is a flag change pushed by the Variably API. Value is the
+// flag's environment-wide value; Enabled is used for boolean flags that omit it.
+type FlagUpdateMessage struct {
+	FlagKey   string      `json:"flag_key"`
+	Enabled   bool        `json:"enabled"`
+	Value     interface{} `json:"value,omitempty"`
+	Variation string      `json:"variation,omitempty"`
+	Version   string      `json:"version,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty"`
+}
+
+// FlagValue returns the updated value, falling back to Enabled for boolean flags
+func (m *FlagUpdateMessage) FlagValue() interface{} {
+	if m.Value != nil {
+		return m.Value
+	}
+	return m.Enabled
+}
+
+// streamEvent is a single event received from a real-time transport
+type streamEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// eventStream is an open real-time connection, over SSE or WebSocket
+type eventStream interface {
+	// Next blocks until the next event arrives or the connection fails
+	Next() (streamEvent, error)
+	// RetryDelay returns the reconnection delay requested by the server, if any
+	RetryDelay() time.Duration
+	Close() error
+}
+
+// openEventStream connects using the configured real-time transport
+func (c *VariablyClient) openEventStream(ctx context.Context, lastEventID string) (eventStream, error) {
+	if c.config.RealTimeConfig.Transport == RealTimeTransportWebSocket {
+		conn, err := c.httpClient.openWebSocket(ctx, lastEventID, c.config.RealTimeConfig.HeartbeatInterval)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	body, err := c.httpClient.OpenStream(ctx, lastEventID)
+	if err != nil {
+		return nil, err
+	}
+	return newSSEReader(body), nil
+}
+
+// startStreaming keeps a real-time connection open and applies the flag
+// changes it receives. Dropped connections are re-established with
+// exponential backoff, resuming after the last event received. The backoff
+// only starts over once a connection has stayed up for streamStableAfter.
+func (c *VariablyClient) startStreaming() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.stopCh
+		cancel()
+	}()
+
+	var lastEventID string
+	var retry time.Duration // minimum delay requested by the server
+	attempt := 0
+	for {
+		stream, err := c.openEventStream(ctx, lastEventID)
+		if err == nil {
+			c.logger.Info("Connected to flag stream", "transport", c.config.RealTimeConfig.Transport)
+			connected := time.Now()
+
+			lastEventID, err = c.readStream(stream, lastEventID)
+			stream.Close()
+
+			if time.Since(connected) >= streamStableAfter {
+				attempt = 0
+			}
+
+			if delay := stream.RetryDelay(); delay > 0 {
+				retry = delay
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		attempt++
+		delay := c.httpClient.calculateBackoff(attempt)
+		if delay < retry {
+			delay = retry
+		}
+		c.logger.Warn("Flag stream disconnected, reconnecting", "error", err, "attempt", attempt, "delay", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readStream applies events from a stream until it fails, returning the ID
+// of the last event received
+func (c *VariablyClient) readStream(stream eventStream, lastEventID string) (string, error) {
+	for {
+		event, err := stream.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return lastEventID, err
+		}
+		if event.ID != "" {
+			lastEventID = event.ID
+		}
+
+		switch event.Event {
+		case "flag_update", "message":
+			var update FlagUpdateMessage
+			if err := json.Unmarshal([]byte(event.Data), &update); err != nil || update.FlagKey == "" {
+				c.logger.Warn("Ignoring malformed flag update", "event_id", event.ID, "error", err)
+				continue
+			}
+			c.applyFlagUpdate(update)
+		default:
+			c.logger.Debug("Ignoring stream event", "event", event.Event, "event_id", event.ID)
+		}
+	}
+}
+
+// applyFlagUpdate invalidates cached values for an updated flag and notifies
+// its subscribers. Callbacks run synchronously on the update's goroutine.
+func (c *VariablyClient) applyFlagUpdate(update FlagUpdateMessage) {
+	c.evaluator.InvalidateFlag(update.FlagKey)
+	c.flagsChanged([]string{update.FlagKey})
+	c.recordFlagValue(update)
+
+	c.logger.Debug("Flag updated", "flag_key", update.FlagKey, "version", update.Version, "deleted", update.Deleted)
+	c.notifySubscribers(update)
+}
+
+// flagsChanged wakes the watchers of changed flags. With local evaluation
+// the ruleset is reloaded first, and watchers are woken once it has loaded.
+func (c *VariablyClient) flagsChanged(flagKeys []string) {
+	if c.config.LocalEvaluationConfig.Enabled {
+		c.reloadRuleset()
+		return
+	}
+	c.signalWatchers(flagKeys)
+}
+
+// reloadRuleset downloads the latest ruleset in the background. Requests made
+// while a download is running are coalesced into a single further download,
+// so a burst of flag changes costs at most two.
+func (c *VariablyClient) reloadRuleset() {
+	c.reloadMutex.Lock()
+	defer c.reloadMutex.Unlock()
+
+	if c.reloading {
+		c.reloadPending = true
+		return
+	}
+	c.reloading = true
+
+	go func() {
+		for {
+			select {
+			case <-c.stopCh:
+			default:
+				ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+				if err := c.evaluator.LoadRuleset(ctx); err != nil {
+					c.logger.Warn("Failed to refresh ruleset", "error", err)
+				} else {
+					// Watched flags may have changed in the new ruleset
+					c.signalWatchers(nil)
+				}
+				cancel()
+			}
+
+			c.reloadMutex.Lock()
+			if !c.reloadPending {
+				c.reloading = false
+				c.reloadMutex.Unlock()
+				return
+			}
+			c.reloadPending = false
+			c.reloadMutex.Unlock()
+		}
+	}()
+}
+
+// recordFlagValue stores a flag's latest value, reporting whether it differs
+// from the last one known. A deleted flag counts as changed only if it was known.
+func (c *VariablyClient) recordFlagValue(update FlagUpdateMessage) bool {
+	c.valuesMutex.Lock()
+	defer c.valuesMutex.Unlock()
+
+	previous, known := c.flagValues[update.FlagKey]
+	if update.Deleted {
+		delete(c.flagValues, update.FlagKey)
+		return known
+	}
+
+	value := update.FlagValue()
+	c.flagValues[update.FlagKey] = value
+	return !known || !reflect.DeepEqual(previous, value)
+}
+
+// notifySubscribers passes a flag's new value to its subscribers
+func (c *VariablyClient) notifySubscribers(update FlagUpdateMessage) {
+	subscribers := c.matchingSubscribers(update.FlagKey)
+	if len(subscribers) == 0 {
+		return
+	}
+
+	result := FlagResult{
+		Key:         update.FlagKey,
+		Value:       update.FlagValue(),
+		Variation:   update.Variation,
+		Reason:      ReasonDefault,
+		EvaluatedAt: time.Now(),
+	}
+	if update.Deleted {
+		result.Value = nil
+		result.Reason = ReasonError
+		result.ErrorCode = ErrorCodeFlagNotFound
+		result.Error = fmt.Errorf("flag %s was deleted", update.FlagKey)
+	}
+
+	for _, sub := range subscribers {
+		sub.callback(update.FlagKey, result)
+	}
+}
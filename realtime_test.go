@@ -0,0 +1,714 @@
+package variably
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSEReader(t *testing.T) {
+	stream := ": heartbeat\n" +
+		"retry: 5000\n" +
+		"id: 1\n" +
+		"event: flag_update\n" +
+		"data: {\"flag_key\":\n" +
+		"data: \"a\"}\n" +
+		"\n" +
+		"data: plain\r\n" +
+		"\r\n"
+
+	reader := newSSEReader(strings.NewReader(stream))
+
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "1" || event.Event != "flag_update" || event.Data != "{\"flag_key\":\n\"a\"}" {
+		t.Errorf("Unexpected first event %+v", event)
+	}
+	if reader.retry != 5*time.Second {
+		t.Errorf("Expected retry of 5s, got %s", reader.retry)
+	}
+
+	event, err = reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.ID != "" || event.Event != "message" || event.Data != "plain" {
+		t.Errorf("Unexpected second event %+v", event)
+	}
+
+	if _, err := reader.Next(); err == nil {
+		t.Error("Expected end of stream")
+	}
+}
+
+func TestStreamingUpdates(t *testing.T) {
+	var value atomic.Value
+	value.Store("blue")
+	var evaluations, connections int32
+	ready := make(chan struct{})
+	lastEventID := make(chan string, 1)
+
+	sendUpdate := func(w http.ResponseWriter, id, color string) {
+		fmt.Fprintf(w, "id: %s\nevent: flag_update\ndata: {\"flag_key\":\"color\",\"value\":%q}\n\n", id, color)
+		w.(http.Flusher).Flush()
+	}
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/evaluate":
+			atomic.AddInt32(&evaluations, 1)
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: value.Load()})
+		case "/api/v1/sdk/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+
+			if atomic.AddInt32(&connections, 1) == 1 {
+				// Send one update, then drop the connection
+				<-ready
+				value.Store("green")
+				sendUpdate(w, "1", "green")
+				return
+			}
+
+			lastEventID <- r.Header.Get("Last-Event-ID")
+			value.Store("red")
+			sendUpdate(w, "2", "red")
+			<-r.Context().Done()
+		}
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	updates := make(chan FlagResult, 2)
+	if _, err := client.Subscribe(ctx, []string{"color"}, func(flagKey string, result FlagResult) {
+		updates <- result
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := client.EvaluateFlag(ctx, "color", "none", user); result.Value != "blue" {
+		t.Fatalf("Expected blue, got %v", result.Value)
+	}
+	close(ready)
+
+	for _, want := range []string{"green", "red"} {
+		select {
+		case result := <-updates:
+			if result.Key != "color" || result.Value != want {
+				t.Errorf("Expected update to %s, got %+v", want, result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for update to %s", want)
+		}
+
+		// The cached value was invalidated
+		if result := client.EvaluateFlag(ctx, "color", "none", user); result.Value != want || result.CacheHit {
+			t.Errorf("Expected fresh %s after update, got %v (cache hit %v)", want, result.Value, result.CacheHit)
+		}
+	}
+
+	if id := <-lastEventID; id != "1" {
+		t.Errorf("Expected reconnect to resume after event 1, got Last-Event-ID %q", id)
+	}
+	if got := atomic.LoadInt32(&evaluations); got != 3 {
+		t.Errorf("Expected 3 API evaluations, got %d", got)
+	}
+}
+
+func TestStreamReconnectBackoff(t *testing.T) {
+	connections := make(chan time.Time, 10)
+
+	newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/sdk/stream" {
+			return
+		}
+		// Accept the connection, then drop it straight away
+		connections <- time.Now()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+	})
+
+	var times []time.Time
+	for len(times) < 4 {
+		select {
+		case at := <-connections:
+			times = append(times, at)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("Timed out waiting for connection %d", len(times)+1)
+		}
+	}
+
+	// Short-lived connections keep backing off: 200ms, 400ms, then 800ms ±25%
+	if gap := times[3].Sub(times[2]); gap < 500*time.Millisecond {
+		t.Errorf("Expected the backoff to keep growing, third reconnect came after %s", gap)
+	}
+}
+
+// writeServerFrame writes an unmasked frame, as a WebSocket server would
+func writeServerFrame(w *bufio.ReadWriter, fin bool, opcode byte, payload []byte) error {
+	header := opcode
+	if fin {
+		header |= 0x80
+	}
+	frame := []byte{header}
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	w.Write(append(frame, payload...))
+	return w.Flush()
+}
+
+// readClientFrame reads a frame from the client, which must be masked
+func readClientFrame(r *bufio.ReadWriter) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, fmt.Errorf("client frame not masked")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var extended [2]byte
+		if _, err := io.ReadFull(r, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(extended[:]))
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0F, payload, nil
+}
+
+func TestWebSocketUpdates(t *testing.T) {
+	var connections int32
+	ready := make(chan struct{})
+	pong := make(chan string, 1)
+	lastEventID := make(chan string, 1)
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/sdk/ws" {
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: "blue"})
+			return
+		}
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("X-API-Key") != "test-key" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		rw.Flush()
+
+		if atomic.AddInt32(&connections, 1) == 1 {
+			// The client answers pings, then must notice when the server goes silent
+			writeServerFrame(rw, true, wsOpPing, []byte("hb"))
+			for {
+				opcode, payload, err := readClientFrame(rw)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if opcode == wsOpPong {
+					pong <- string(payload)
+					break
+				}
+			}
+
+			<-ready
+			writeServerFrame(rw, true, wsOpText, []byte(`{"id":"1","event":"flag_update","data":{"flag_key":"color","value":"green"}}`))
+		} else {
+			lastEventID <- r.Header.Get("Last-Event-ID")
+
+			// Fragmented across two frames
+			writeServerFrame(rw, false, wsOpText, []byte(`{"id":"2","data":{"flag_key":`))
+			writeServerFrame(rw, true, wsOpContinuation, []byte(`"color","value":"red"}}`))
+		}
+
+		// Drain client frames until the client hangs up
+		for {
+			if _, _, err := readClientFrame(rw); err != nil {
+				return
+			}
+		}
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+		config.RealTimeConfig.Transport = RealTimeTransportWebSocket
+		config.RealTimeConfig.HeartbeatInterval = 50 * time.Millisecond
+	})
+
+	updates := make(chan FlagResult, 2)
+	if _, err := client.Subscribe(context.Background(), []string{"color"}, func(flagKey string, result FlagResult) {
+		updates <- result
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-pong:
+		if payload != "hb" {
+			t.Errorf("Expected pong to echo ping payload, got %q", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for pong")
+	}
+	close(ready)
+
+	for _, want := range []string{"green", "red"} {
+		select {
+		case result := <-updates:
+			if result.Key != "color" || result.Value != want {
+				t.Errorf("Expected update to %s, got %+v", want, result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for update to %s", want)
+		}
+	}
+
+	if id := <-lastEventID; id != "1" {
+		t.Errorf("Expected reconnect to resume after event 1, got Last-Event-ID %q", id)
+	}
+}
+
+func TestPollingUpdates(t *testing.T) {
+	var value atomic.Value
+	value.Store("blue")
+	sinces := make(chan string, 10)
+	ready := make(chan struct{})
+	var evaluations int32
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/evaluate":
+			atomic.AddInt32(&evaluations, 1)
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: value.Load()})
+		case "/api/v1/sdk/changes":
+			since := r.URL.Query().Get("since")
+			select {
+			case sinces <- since:
+			default:
+			}
+
+			// Once caught up, the server reports no version
+			var resp FlagChangesResponse
+			switch since {
+			case "":
+				resp.Version = "1"
+				resp.Changes = []FlagUpdateMessage{
+					{FlagKey: "color", Value: "blue"},
+					{FlagKey: "size", Value: 1.0},
+				}
+			case "1":
+				<-ready
+				value.Store("green")
+				resp.Version = "2"
+				resp.Changes = []FlagUpdateMessage{
+					{FlagKey: "color", Value: "green"},
+					// Changed targeting, same environment-wide value
+					{FlagKey: "size", Value: 1.0},
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		}
+	}, func(config *Config) {
+		config.PollingConfig = PollingConfig{
+			Enabled:  true,
+			Interval: 10 * time.Millisecond,
+			Jitter:   10 * time.Millisecond,
+		}
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	updates := make(chan FlagResult, 4)
+	if _, err := client.Subscribe(ctx, []string{"color", "size"}, func(flagKey string, result FlagResult) {
+		updates <- result
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := client.EvaluateFlag(ctx, "color", "none", user); result.Value != "blue" {
+		t.Fatalf("Expected blue, got %v", result.Value)
+	}
+	close(ready)
+
+	select {
+	case result := <-updates:
+		if result.Key != "color" || result.Value != "green" {
+			t.Errorf("Expected update of color to green, got %+v", result)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for update")
+	}
+
+	// Later polls resume from the last version returned and report nothing new
+	for _, want := range []string{"", "1", "2", "2"} {
+		if since := <-sinces; since != want {
+			t.Errorf("Expected poll since %q, got %q", want, since)
+		}
+	}
+	select {
+	case result := <-updates:
+		t.Errorf("Unexpected update %+v", result)
+	default:
+	}
+
+	// The new value is applied to the cache without another evaluation
+	if result := client.EvaluateFlag(ctx, "color", "none", user); result.Value != "green" || !result.CacheHit {
+		t.Errorf("Expected cached green after update, got %v (cache hit %v)", result.Value, result.CacheHit)
+	}
+	if n := atomic.LoadInt32(&evaluations); n != 1 {
+		t.Errorf("Expected 1 evaluation, got %d", n)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	var version int32
+	var evaluations int32
+	ready := make(chan struct{})
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/evaluate":
+			var req EvaluateFlagRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Context.UserID == "u1" {
+				atomic.AddInt32(&evaluations, 1)
+			}
+
+			// Version 1 only changes the value for u2
+			value := "blue"
+			switch v := atomic.LoadInt32(&version); {
+			case v == 1 && req.Context.UserID == "u2":
+				value = "red"
+			case v == 2:
+				value = "green"
+			}
+			json.NewEncoder(w).Encode(EvaluateFlagResponse{Value: value})
+		case "/api/v1/sdk/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+
+			<-ready
+			for _, v := range []int32{1, 2} {
+				atomic.StoreInt32(&version, v)
+				fmt.Fprintf(w, "id: %d\nevent: flag_update\ndata: {\"flag_key\":\"color\",\"value\":\"blue\"}\n\n", v)
+				w.(http.Flusher).Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+			<-r.Context().Done()
+		}
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := client.Watch(ctx, "color", UserContext{UserID: "u1"})
+
+	next := func() (FlagResult, bool) {
+		select {
+		case result, ok := <-results:
+			return result, ok
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for watch result")
+			return FlagResult{}, false
+		}
+	}
+
+	if result, _ := next(); result.Value != "blue" {
+		t.Fatalf("Expected initial blue, got %+v", result)
+	}
+	close(ready)
+
+	// The change for u2 is evaluated but not emitted
+	if result, _ := next(); result.Value != "green" {
+		t.Errorf("Expected change to green, got %+v", result)
+	}
+	if got := atomic.LoadInt32(&evaluations); got != 3 {
+		t.Errorf("Expected 3 evaluations for u1, got %d", got)
+	}
+
+	cancel()
+	if _, ok := next(); ok {
+		t.Error("Expected channel to close after cancellation")
+	}
+
+	// Closing the client closes outstanding watches
+	results = client.Watch(context.Background(), "color", UserContext{UserID: "u1"})
+	next()
+	client.Close()
+	if _, ok := next(); ok {
+		t.Error("Expected channel to close with the client")
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}, func(config *Config) {
+		config.PollingConfig = PollingConfig{Enabled: true, Interval: time.Hour}
+	})
+
+	ctx := context.Background()
+	received := make(map[string][]string)
+	subscribe := func(name string, flagKeys ...string) *Subscription {
+		sub, err := client.Subscribe(ctx, flagKeys, func(flagKey string, result FlagResult) {
+			received[name] = append(received[name], flagKey)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+
+	if _, err := client.Subscribe(ctx, nil, func(string, FlagResult) {}); err == nil {
+		t.Error("Expected subscription without flag keys to be rejected")
+	}
+	if got := client.GetMetrics().ActiveSubscribers; got != 0 {
+		t.Errorf("Expected no active subscribers, got %d", got)
+	}
+
+	first := subscribe("first", "color")
+	subscribe("second", "color")
+	subscribe("checkout", "checkout.*", "checkout.button")
+	subscribe("all", "*")
+
+	if got := client.GetMetrics().ActiveSubscribers; got != 4 {
+		t.Errorf("Expected 4 active subscribers, got %d", got)
+	}
+
+	// Unsubscribing one handle leaves other subscribers to the same flag in place
+	first.Unsubscribe()
+	first.Unsubscribe()
+	if got := client.GetMetrics().ActiveSubscribers; got != 3 {
+		t.Errorf("Expected 3 active subscribers, got %d", got)
+	}
+
+	for _, flagKey := range []string{"color", "checkout.button", "checkout_legacy"} {
+		client.applyFlagUpdate(FlagUpdateMessage{FlagKey: flagKey, Value: "x"})
+	}
+
+	expected := map[string][]string{
+		"second":   {"color"},
+		"checkout": {"checkout.button"},
+		"all":      {"color", "checkout.button", "checkout_legacy"},
+	}
+	for name, want := range expected {
+		if got := strings.Join(received[name], ","); got != strings.Join(want, ",") {
+			t.Errorf("Expected %s to receive %v, got %v", name, want, received[name])
+		}
+	}
+	if len(received["first"]) != 0 {
+		t.Errorf("Expected no updates after unsubscribe, got %v", received["first"])
+	}
+
+	// The key-based Unsubscribe still removes every callback for a key
+	client.Unsubscribe([]string{"*"})
+	if got := client.GetMetrics().ActiveSubscribers; got != 2 {
+		t.Errorf("Expected 2 active subscribers, got %d", got)
+	}
+}
+
+func TestWatchPrerequisiteChange(t *testing.T) {
+	var apiEnabled int32 = 1
+	ready := make(chan struct{})
+	onOff := []Variation{{Key: "on", Value: true}, {Key: "off", Value: false}}
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/ruleset":
+			json.NewEncoder(w).Encode(Ruleset{Flags: map[string]FlagDefinition{
+				"new_api": {Key: "new_api", Enabled: atomic.LoadInt32(&apiEnabled) == 1, Variations: onOff, OffVariation: "off", Fallthrough: Rollout{Variation: "on"}},
+				"checkout": {Key: "checkout", Enabled: true, Variations: onOff, OffVariation: "off", Fallthrough: Rollout{Variation: "on"},
+					Prerequisites: []Prerequisite{{Key: "new_api", Variation: "on"}}},
+			}})
+		case "/api/v1/sdk/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+
+			<-ready
+			atomic.StoreInt32(&apiEnabled, 0)
+			fmt.Fprint(w, "id: 1\nevent: flag_update\ndata: {\"flag_key\":\"new_api\",\"enabled\":false}\n\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+		config.LocalEvaluationConfig.Enabled = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	results := client.Watch(ctx, "checkout", UserContext{UserID: "u1"})
+
+	for i, want := range []bool{true, false} {
+		select {
+		case result := <-results:
+			if result.Value != want {
+				t.Errorf("Expected checkout to be %v, got %+v", want, result)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for checkout to be %v", want)
+		}
+		if i == 0 {
+			// Only the prerequisite changes upstream
+			close(ready)
+		}
+	}
+}
+
+func TestSSEEventLimit(t *testing.T) {
+	t.Run("line", func(t *testing.T) {
+		// A line that never ends must not be buffered whole
+		line := io.MultiReader(strings.NewReader("data: "), &repeatReader{b: 'x'})
+		if _, err := newSSEReader(line).Next(); err != errSSEEventTooLarge {
+			t.Fatalf("Expected event too large, got %v", err)
+		}
+	})
+
+	t.Run("event", func(t *testing.T) {
+		// Each line fits the limit, but the event does not
+		line := "data: " + strings.Repeat("x", maxSSEEvent/2) + "\n"
+		stream := strings.NewReader(line + line + line + "\n")
+		if _, err := newSSEReader(stream).Next(); err != errSSEEventTooLarge {
+			t.Fatalf("Expected event too large, got %v", err)
+		}
+		if stream.Len() == 0 {
+			t.Error("Expected reading to stop before the end of the event")
+		}
+	})
+}
+
+// repeatReader endlessly repeats a byte
+type repeatReader struct {
+	b byte
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.b
+	}
+	return len(p), nil
+}
+
+func TestWebSocketMessageLimit(t *testing.T) {
+	var frames bytes.Buffer
+	server := bufio.NewReadWriter(bufio.NewReader(&frames), bufio.NewWriter(&frames))
+
+	// Each fragment fits the limit, but the message does not
+	fragment := bytes.Repeat([]byte("x"), maxWebSocketMessage/2+1)
+	writeServerFrame(server, false, wsOpText, fragment)
+	writeServerFrame(server, false, wsOpContinuation, fragment)
+	writeServerFrame(server, true, wsOpContinuation, fragment)
+
+	conn := &wsConn{reader: bufio.NewReader(&frames)}
+	if _, err := conn.Next(); err != errWebSocketMessageTooLarge {
+		t.Fatalf("Expected message too large, got %v", err)
+	}
+	if len(conn.message) > maxWebSocketMessage {
+		t.Errorf("Expected buffer to stay within the limit, got %d bytes", len(conn.message))
+	}
+	if frames.Len() == 0 {
+		t.Error("Expected reading to stop before the final fragment")
+	}
+}
+
+func TestRulesetReloadCoalescing(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	const events = 10
+
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/ruleset":
+			// Hold the first reload until every event has arrived
+			if atomic.AddInt32(&fetches, 1) == 2 {
+				<-release
+			}
+			json.NewEncoder(w).Encode(Ruleset{Flags: map[string]FlagDefinition{}})
+		case "/api/v1/sdk/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			for i := 1; i <= events; i++ {
+				fmt.Fprintf(w, "id: %d\nevent: flag_update\ndata: {\"flag_key\":\"color\",\"value\":%d}\n\n", i, i)
+			}
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}, func(config *Config) {
+		config.EnableRealTimeSync = true
+		config.LocalEvaluationConfig.Enabled = true
+	})
+	var releaseOnce sync.Once
+	releaseAll := func() { releaseOnce.Do(func() { close(release) }) }
+	t.Cleanup(releaseAll)
+
+	updates := make(chan FlagResult, events)
+	if _, err := client.Subscribe(context.Background(), []string{"color"}, func(flagKey string, result FlagResult) {
+		updates <- result
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < events; i++ {
+		select {
+		case <-updates:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for update %d", i+1)
+		}
+	}
+	releaseAll()
+
+	// The initial download, the held reload and one more for the rest of the burst
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&fetches) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("Expected 3 ruleset downloads, got %d", n)
+	}
+}
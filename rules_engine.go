@@ -0,0 +1,545 @@
+package variably
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RulesEngine evaluates flag and gate definitions from a downloaded ruleset in-process
+type RulesEngine struct {
+	logger  Logger
+	regexes sync.Map // pattern -> *regexp.Regexp
+
+	// segments looks up segments referenced by in_segment conditions
+	segments func(key string) (*segment, bool)
+	// flags looks up flags referenced as prerequisites
+	flags func(key string) (FlagDefinition, bool)
+}
+
+// flagEvaluation tracks the state of evaluating one flag and its prerequisites
+type flagEvaluation struct {
+	visiting map[string]bool       // flags currently being evaluated, for cycle detection
+	results  map[string]FlagResult // prerequisite results, so shared prerequisites evaluate once
+}
+
+// maxSegmentDepth bounds how deeply segments may reference other segments,
+// which also stops evaluation of segments that reference themselves
+const maxSegmentDepth = 8
+
+// NewRulesEngine creates a new rules engine
+func NewRulesEngine(logger Logger) *RulesEngine {
+	return &RulesEngine{
+		logger: logger,
+	}
+}
+
+// EvaluateFlag evaluates a flag definition for the given user
+func (r *RulesEngine) EvaluateFlag(def FlagDefinition, defaultValue interface{}, userContext UserContext) FlagResult {
+	evaluation := &flagEvaluation{
+		visiting: make(map[string]bool),
+		results:  make(map[string]FlagResult),
+	}
+	return r.evaluateFlag(def, defaultValue, userContext, evaluation)
+}
+
+// evaluateFlag evaluates a flag definition as part of an evaluation
+func (r *RulesEngine) evaluateFlag(def FlagDefinition, defaultValue interface{}, userContext UserContext, evaluation *flagEvaluation) FlagResult {
+	result := FlagResult{
+		Key:         def.Key,
+		Value:       defaultValue,
+		EvaluatedAt: time.Now(),
+		CacheHit:    false,
+	}
+
+	if !def.Enabled {
+		result.Reason = ReasonDisabled
+		if def.OffVariation != "" {
+			r.serveVariation(&def, def.OffVariation, &result)
+		}
+		return result
+	}
+
+	if !r.prerequisitesMet(&def, userContext, evaluation, &result) {
+		return result
+	}
+
+	for _, rule := range def.Rules {
+		if !r.matchesAll(rule.Conditions, userContext, 0) {
+			continue
+		}
+		result.RuleID = rule.ID
+		result.Reason = ReasonTargetingMatch
+		r.serveRollout(&def, rule.Rollout, userContext, &result)
+		return result
+	}
+
+	result.Reason = ReasonDefault
+	r.serveRollout(&def, def.Fallthrough, userContext, &result)
+	return result
+}
+
+// prerequisitesMet evaluates the flag's prerequisites in order. If one is not
+// met, the result explains which and serves the off variation, if any.
+func (r *RulesEngine) prerequisitesMet(def *FlagDefinition, userContext UserContext, evaluation *flagEvaluation, result *FlagResult) bool {
+	if len(def.Prerequisites) == 0 {
+		return true
+	}
+
+	evaluation.visiting[def.Key] = true
+	defer delete(evaluation.visiting, def.Key)
+
+	for _, prerequisite := range def.Prerequisites {
+		if evaluation.visiting[prerequisite.Key] {
+			r.logger.Warn("Prerequisite cycle detected", "flag_key", def.Key, "prerequisite", prerequisite.Key)
+			result.Reason = ReasonError
+			result.ErrorCode = ErrorCodeGeneral
+			result.RuleID = prerequisiteRuleID(prerequisite.Key)
+			result.Error = fmt.Errorf("prerequisite cycle between flags %q and %q", def.Key, prerequisite.Key)
+			return false
+		}
+
+		prerequisiteResult, evaluated := evaluation.results[prerequisite.Key]
+		if !evaluated {
+			prerequisiteResult = r.evaluatePrerequisite(prerequisite.Key, userContext, evaluation)
+			evaluation.results[prerequisite.Key] = prerequisiteResult
+		}
+
+		if prerequisiteResult.Error != nil || prerequisiteResult.Reason == ReasonDisabled || prerequisiteResult.Variation != prerequisite.Variation {
+			result.Reason = ReasonPrerequisiteFailed
+			result.RuleID = prerequisiteRuleID(prerequisite.Key)
+			if def.OffVariation != "" {
+				r.serveVariation(def, def.OffVariation, result)
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
+// evaluatePrerequisite evaluates the flag a prerequisite refers to
+func (r *RulesEngine) evaluatePrerequisite(key string, userContext UserContext, evaluation *flagEvaluation) FlagResult {
+	var def FlagDefinition
+	exists := false
+	if r.flags != nil {
+		def, exists = r.flags(key)
+	}
+	if !exists {
+		r.logger.Warn("Prerequisite flag not found", "flag_key", key)
+		return FlagResult{
+			Key:         key,
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeFlagNotFound,
+			Error:       fmt.Errorf("prerequisite flag %q not found", key),
+			EvaluatedAt: time.Now(),
+		}
+	}
+
+	// Cycle detection is keyed on Key, so it must match the key looked up
+	if def.Key == "" {
+		def.Key = key
+	}
+
+	return r.evaluateFlag(def, nil, userContext, evaluation)
+}
+
+// prerequisiteRuleID identifies a failed prerequisite in FlagResult.RuleID
+func prerequisiteRuleID(flagKey string) string {
+	return "prerequisite:" + flagKey
+}
+
+// EvaluateGate evaluates a gate definition for the given user
+func (r *RulesEngine) EvaluateGate(def GateDefinition, userContext UserContext) bool {
+	if !def.Enabled {
+		return false
+	}
+
+	for _, rule := range def.Rules {
+		if !r.matchesAll(rule.Conditions, userContext, 0) {
+			continue
+		}
+		if rule.Percentage >= 100 {
+			return true
+		}
+		bucket, ok := BucketUser(userContext, def.Salt, def.Key, def.BucketBy)
+		return ok && bucketPercentage(bucket) < rule.Percentage
+	}
+
+	return false
+}
+
+// EvaluateSegment reports whether the user is a member of the segment
+func (r *RulesEngine) EvaluateSegment(def SegmentDefinition, userContext UserContext) bool {
+	return r.inSegment(newSegment(def), userContext, 0)
+}
+
+// inSegment reports whether the user is a member of an indexed segment
+func (r *RulesEngine) inSegment(s *segment, userContext UserContext, depth int) bool {
+	if userContext.UserID != "" {
+		if _, excluded := s.excluded[userContext.UserID]; excluded {
+			return false
+		}
+		if _, included := s.included[userContext.UserID]; included {
+			return true
+		}
+	}
+
+	for _, rule := range s.Rules {
+		if r.matchesAll(rule.Conditions, userContext, depth) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentMember resolves a segment by key and reports whether the user belongs to it
+func (r *RulesEngine) segmentMember(key string, userContext UserContext, depth int) bool {
+	if depth >= maxSegmentDepth {
+		r.logger.Warn("Segment nesting too deep, treating as no match", "segment_key", key)
+		return false
+	}
+
+	if r.segments == nil {
+		return false
+	}
+	s, ok := r.segments(key)
+	if !ok {
+		r.logger.Warn("Condition references unknown segment", "segment_key", key)
+		return false
+	}
+	return r.inSegment(s, userContext, depth+1)
+}
+
+// serveRollout resolves a rollout to a variation and writes it to the result
+func (r *RulesEngine) serveRollout(def *FlagDefinition, rollout Rollout, userContext UserContext, result *FlagResult) {
+	if len(rollout.Weights) == 0 {
+		r.serveVariation(def, rollout.Variation, result)
+		return
+	}
+
+	bucket, ok := BucketUser(userContext, def.Salt, def.Key, def.BucketBy)
+	if !ok {
+		result.Reason = ReasonError
+		result.ErrorCode = ErrorCodeGeneral
+		result.Error = fmt.Errorf("cannot bucket user without a value for %q", bucketAttribute(def.BucketBy))
+		return
+	}
+	percentage := bucketPercentage(bucket)
+
+	var cumulative float64
+	for _, weighted := range rollout.Weights {
+		cumulative += weighted.Weight
+		if percentage < cumulative {
+			result.Reason = ReasonSplit
+			r.serveVariation(def, weighted.Variation, result)
+			return
+		}
+	}
+
+	// Weights summing to less than 100 leave the remainder on the last variation
+	result.Reason = ReasonSplit
+	r.serveVariation(def, rollout.Weights[len(rollout.Weights)-1].Variation, result)
+}
+
+// serveVariation writes the named variation's value to the result
+func (r *RulesEngine) serveVariation(def *FlagDefinition, key string, result *FlagResult) {
+	variation, ok := def.variation(key)
+	if !ok {
+		r.logger.Warn("Flag references unknown variation", "flag_key", def.Key, "variation", key)
+		result.Reason = ReasonError
+		result.ErrorCode = ErrorCodeGeneral
+		result.Error = fmt.Errorf("variation %q not found in flag %q", key, def.Key)
+		return
+	}
+	result.Value = variation.Value
+	result.Variation = variation.Key
+}
+
+// matchesAll reports whether the user satisfies every condition
+func (r *RulesEngine) matchesAll(conditions []Condition, userContext UserContext, depth int) bool {
+	for _, condition := range conditions {
+		if !r.matches(condition, userContext, depth) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the user satisfies a single condition.
+// A missing attribute never matches, regardless of Negate.
+func (r *RulesEngine) matches(condition Condition, userContext UserContext, depth int) bool {
+	if condition.Operator == OperatorInSegment {
+		matched := r.anyValue(condition.Values, func(key interface{}) bool {
+			return r.segmentMember(fmt.Sprint(key), userContext, depth)
+		})
+		if condition.Negate {
+			return !matched
+		}
+		return matched
+	}
+
+	actual, ok := userAttribute(userContext, condition.Attribute)
+	if !ok {
+		return false
+	}
+
+	matched := false
+	switch condition.Operator {
+	case OperatorIn:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool { return valuesEqual(actual, expected) })
+	case OperatorNotIn:
+		matched = !r.anyValue(condition.Values, func(expected interface{}) bool { return valuesEqual(actual, expected) })
+	case OperatorContains:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			return strings.Contains(fmt.Sprint(actual), fmt.Sprint(expected))
+		})
+	case OperatorStartsWith:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			return strings.HasPrefix(fmt.Sprint(actual), fmt.Sprint(expected))
+		})
+	case OperatorEndsWith:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			return strings.HasSuffix(fmt.Sprint(actual), fmt.Sprint(expected))
+		})
+	case OperatorMatches:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			re := r.compile(fmt.Sprint(expected))
+			return re != nil && re.MatchString(fmt.Sprint(actual))
+		})
+	case OperatorGreater, OperatorGreaterEq, OperatorLess, OperatorLessEq:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			return compareNumbers(condition.Operator, actual, expected)
+		})
+	case OperatorSemverEq, OperatorSemverGt, OperatorSemverLt:
+		matched = r.anyValue(condition.Values, func(expected interface{}) bool {
+			return compareSemver(condition.Operator, fmt.Sprint(actual), fmt.Sprint(expected))
+		})
+	default:
+		r.logger.Warn("Unknown condition operator", "operator", condition.Operator, "attribute", condition.Attribute)
+		return false
+	}
+
+	if condition.Negate {
+		return !matched
+	}
+	return matched
+}
+
+// anyValue reports whether fn holds for any of the values
+func (r *RulesEngine) anyValue(values []interface{}, fn func(interface{}) bool) bool {
+	for _, value := range values {
+		if fn(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile returns a cached compiled regular expression, or nil if invalid
+func (r *RulesEngine) compile(pattern string) *regexp.Regexp {
+	if cached, ok := r.regexes.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		r.logger.Warn("Invalid regular expression in condition", "pattern", pattern, "error", err)
+		return nil
+	}
+	r.regexes.Store(pattern, re)
+	return re
+}
+
+// userAttribute resolves a named attribute from the user context.
+// Built-in fields take precedence over custom attributes of the same name.
+func userAttribute(userContext UserContext, name string) (interface{}, bool) {
+	var value string
+	switch name {
+	case "user_id":
+		value = userContext.UserID
+	case "session_id":
+		value = userContext.SessionID
+	case "email":
+		value = userContext.Email
+	case "country":
+		value = userContext.Country
+	case "language":
+		value = userContext.Language
+	case "platform":
+		value = userContext.Platform
+	case "version":
+		value = userContext.Version
+	case "ip_address":
+		value = userContext.IPAddress
+	case "user_agent":
+		value = userContext.UserAgent
+	default:
+		attr, ok := userContext.Attributes[name]
+		if !ok || attr == nil {
+			return nil, false
+		}
+		return attr, true
+	}
+
+	if value == "" {
+		return nil, false
+	}
+	return value, true
+}
+
+// valuesEqual compares two attribute values, treating numbers of any type as equal by value
+func valuesEqual(a, b interface{}) bool {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return af == bf
+		}
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// compareNumbers applies a numeric comparison operator
+func compareNumbers(operator string, actual, expected interface{}) bool {
+	a, ok := toFloat(actual)
+	if !ok {
+		return false
+	}
+	b, ok := toFloat(expected)
+	if !ok {
+		return false
+	}
+
+	switch operator {
+	case OperatorGreater:
+		return a > b
+	case OperatorGreaterEq:
+		return a >= b
+	case OperatorLess:
+		return a < b
+	case OperatorLessEq:
+		return a <= b
+	default:
+		return false
+	}
+}
+
+// toFloat converts numeric values and numeric strings to float64
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// compareSemver applies a semantic version comparison operator
+func compareSemver(operator, actual, expected string) bool {
+	a, ok := parseSemver(actual)
+	if !ok {
+		return false
+	}
+	b, ok := parseSemver(expected)
+	if !ok {
+		return false
+	}
+
+	cmp := a.compare(b)
+	switch operator {
+	case OperatorSemverEq:
+		return cmp == 0
+	case OperatorSemverGt:
+		return cmp > 0
+	case OperatorSemverLt:
+		return cmp < 0
+	default:
+		return false
+	}
+}
+
+type semver struct {
+	parts      [3]int
+	prerelease string
+}
+
+// parseSemver parses versions such as "1.2", "v1.2.3" and "1.2.3-beta.1"
+func parseSemver(version string) (semver, bool) {
+	var v semver
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "+"); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.Index(version, "-"); i >= 0 {
+		v.prerelease = version[i+1:]
+		version = version[:i]
+	}
+
+	fields := strings.Split(version, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return v, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.parts[i] = n
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1. A pre-release sorts before its release.
+func (v semver) compare(other semver) int {
+	for i := range v.parts {
+		if v.parts[i] != other.parts[i] {
+			if v.parts[i] < other.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case v.prerelease == other.prerelease:
+		return 0
+	case v.prerelease == "":
+		return 1
+	case other.prerelease == "":
+		return -1
+	case v.prerelease < other.prerelease:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,463 @@
+package variably
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRuleset() *Ruleset {
+	return &Ruleset{
+		Environment: "test",
+		Version:     "1",
+		Flags: map[string]FlagDefinition{
+			"theme": {
+				Key:     "theme",
+				Enabled: true,
+				Variations: []Variation{
+					{Key: "light", Value: "light"},
+					{Key: "dark", Value: "dark"},
+				},
+				Rules: []TargetingRule{
+					{
+						ID: "beta-users",
+						Conditions: []Condition{
+							{Attribute: "plan", Operator: OperatorIn, Values: []interface{}{"beta", "enterprise"}},
+							{Attribute: "version", Operator: OperatorSemverGt, Values: []interface{}{"2.0.0"}},
+						},
+						Rollout: Rollout{Variation: "dark"},
+					},
+				},
+				Fallthrough: Rollout{Variation: "light"},
+			},
+			"split": {
+				Key:     "split",
+				Enabled: true,
+				Variations: []Variation{
+					{Key: "a", Value: "a"},
+					{Key: "b", Value: "b"},
+				},
+				Fallthrough: Rollout{Weights: []WeightedVariation{
+					{Variation: "a", Weight: 50},
+					{Variation: "b", Weight: 50},
+				}},
+			},
+			"killed": {
+				Key:          "killed",
+				Enabled:      false,
+				Variations:   []Variation{{Key: "off", Value: false}},
+				OffVariation: "off",
+			},
+		},
+		Gates: map[string]GateDefinition{
+			"admin": {
+				Key:     "admin",
+				Enabled: true,
+				Rules: []GateRule{
+					{ID: "staff", Conditions: []Condition{{Attribute: "email", Operator: OperatorEndsWith, Values: []interface{}{"@variably.com"}}}, Percentage: 100},
+				},
+			},
+		},
+	}
+}
+
+func TestRulesEngine(t *testing.T) {
+	engine := NewRulesEngine(NewNoOpLogger())
+	ruleset := testRuleset()
+
+	t.Run("Targeting Match", func(t *testing.T) {
+		user := UserContext{UserID: "u1", Version: "2.1.0", Attributes: map[string]interface{}{"plan": "beta"}}
+		result := engine.EvaluateFlag(ruleset.Flags["theme"], "none", user)
+		if result.Value != "dark" || result.RuleID != "beta-users" {
+			t.Errorf("Expected dark from beta-users rule, got %v (rule %q)", result.Value, result.RuleID)
+		}
+	})
+
+	t.Run("Fallthrough", func(t *testing.T) {
+		user := UserContext{UserID: "u1", Version: "1.9.0", Attributes: map[string]interface{}{"plan": "beta"}}
+		result := engine.EvaluateFlag(ruleset.Flags["theme"], "none", user)
+		if result.Value != "light" || result.RuleID != "" {
+			t.Errorf("Expected light from fallthrough, got %v (rule %q)", result.Value, result.RuleID)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		result := engine.EvaluateFlag(ruleset.Flags["killed"], true, UserContext{UserID: "u1"})
+		if result.Value != false || result.Variation != "off" {
+			t.Errorf("Expected off variation, got %v", result.Value)
+		}
+	})
+
+	t.Run("Split Is Deterministic", func(t *testing.T) {
+		seen := map[interface{}]int{}
+		for i := 0; i < 200; i++ {
+			user := UserContext{UserID: "user-" + strconv.Itoa(i)}
+			first := engine.EvaluateFlag(ruleset.Flags["split"], nil, user)
+			second := engine.EvaluateFlag(ruleset.Flags["split"], nil, user)
+			if first.Value != second.Value {
+				t.Fatalf("Expected stable bucketing for %s", user.UserID)
+			}
+			seen[first.Value]++
+		}
+		if seen["a"] == 0 || seen["b"] == 0 {
+			t.Errorf("Expected both variations to be served, got %v", seen)
+		}
+	})
+
+	t.Run("Gate", func(t *testing.T) {
+		if !engine.EvaluateGate(ruleset.Gates["admin"], UserContext{UserID: "u1", Email: "ops@variably.com"}) {
+			t.Error("Expected staff to pass the admin gate")
+		}
+		if engine.EvaluateGate(ruleset.Gates["admin"], UserContext{UserID: "u2", Email: "user@example.com"}) {
+			t.Error("Expected non-staff to fail the admin gate")
+		}
+	})
+}
+
+func TestLocalEvaluationClient(t *testing.T) {
+	var evaluateCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/sdk/ruleset":
+			json.NewEncoder(w).Encode(testRuleset())
+		default:
+			atomic.AddInt32(&evaluateCalls, 1)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.APIKey = "test-key"
+	config.BaseURL = server.URL
+	config.Logger = NewNoOpLogger()
+	config.LocalEvaluationConfig.Enabled = true
+
+	client, err := NewClient(config)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	user := UserContext{UserID: "u1", Version: "3.0.0", Attributes: map[string]interface{}{"plan": "enterprise"}}
+	for i := 0; i < 5; i++ {
+		if theme := client.EvaluateFlagString(context.Background(), "theme", "none", user); theme != "dark" {
+			t.Errorf("Expected dark, got %v", theme)
+		}
+	}
+
+	if !client.EvaluateGate(context.Background(), "admin", UserContext{UserID: "u1", Email: "a@variably.com"}) {
+		t.Error("Expected admin gate to pass")
+	}
+
+	if result := client.EvaluateFlag(context.Background(), "missing", "fallback", user); result.Value != "fallback" || result.Error == nil {
+		t.Errorf("Expected default with error for unknown flag, got %v", result.Value)
+	}
+
+	if calls := atomic.LoadInt32(&evaluateCalls); calls != 0 {
+		t.Errorf("Expected no per-user API calls, got %d", calls)
+	}
+}
+
+func TestSegments(t *testing.T) {
+	segments := map[string]SegmentDefinition{
+		"beta_users": {
+			Included: []string{"u1", "u2"},
+			Excluded: []string{"u3"},
+			Rules: []SegmentRule{
+				{ID: "beta-plan", Conditions: []Condition{{Attribute: "plan", Operator: OperatorIn, Values: []interface{}{"beta"}}}},
+			},
+		},
+		"eu_beta": {
+			Rules: []SegmentRule{
+				{ID: "eu", Conditions: []Condition{
+					{Attribute: "country", Operator: OperatorIn, Values: []interface{}{"DE", "FR"}},
+					{Operator: OperatorInSegment, Values: []interface{}{"beta_users"}},
+				}},
+			},
+		},
+		"loop": {
+			Rules: []SegmentRule{{ID: "self", Conditions: []Condition{{Operator: OperatorInSegment, Values: []interface{}{"loop"}}}}},
+		},
+	}
+
+	cacheManager := NewCacheManager(CacheConfig{MaxSize: 10, TTL: time.Minute}, NewNoOpLogger())
+	cacheManager.SetSegments(segments)
+	engine := NewRulesEngine(NewNoOpLogger())
+	engine.segments = cacheManager.GetSegment
+
+	member := func(key string, user UserContext) bool {
+		s, _ := cacheManager.GetSegment(key)
+		return engine.inSegment(s, user, 0)
+	}
+
+	t.Run("include and exclude lists", func(t *testing.T) {
+		if !member("beta_users", UserContext{UserID: "u1"}) {
+			t.Error("Expected included user to be a member")
+		}
+		if member("beta_users", UserContext{UserID: "u3", Attributes: map[string]interface{}{"plan": "beta"}}) {
+			t.Error("Expected excluded user not to be a member, even when matching a rule")
+		}
+		if !member("beta_users", UserContext{UserID: "u9", Attributes: map[string]interface{}{"plan": "beta"}}) {
+			t.Error("Expected user matching a rule to be a member")
+		}
+	})
+
+	t.Run("nested segments", func(t *testing.T) {
+		if !member("eu_beta", UserContext{UserID: "u2", Country: "DE"}) {
+			t.Error("Expected EU beta user to be a member")
+		}
+		if member("eu_beta", UserContext{UserID: "u2", Country: "US"}) {
+			t.Error("Expected non-EU user not to be a member")
+		}
+		if member("loop", UserContext{UserID: "u1"}) {
+			t.Error("Expected self-referencing segment not to match")
+		}
+	})
+
+	t.Run("flags reference segments", func(t *testing.T) {
+		def := FlagDefinition{
+			Key:         "beta_banner",
+			Enabled:     true,
+			Variations:  []Variation{{Key: "on", Value: true}, {Key: "off", Value: false}},
+			Rules:       []TargetingRule{{ID: "beta", Conditions: []Condition{{Operator: OperatorInSegment, Values: []interface{}{"beta_users"}}}, Rollout: Rollout{Variation: "on"}}},
+			Fallthrough: Rollout{Variation: "off"},
+		}
+		if result := engine.EvaluateFlag(def, false, UserContext{UserID: "u2"}); result.Value != true || result.RuleID != "beta" {
+			t.Errorf("Expected segment rule to match, got %v (%s)", result.Value, result.Reason)
+		}
+		if result := engine.EvaluateFlag(def, false, UserContext{UserID: "u4"}); result.Value != false {
+			t.Errorf("Expected fallthrough for non-member, got %v", result.Value)
+		}
+	})
+}
+
+func TestIsInSegment(t *testing.T) {
+	var fetches int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/sdk/segments" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(SegmentsResponse{
+			Version:  "1",
+			Segments: map[string]SegmentDefinition{"enterprise_accounts": {Included: []string{"acme"}}},
+		})
+	}, nil)
+
+	ctx := context.Background()
+	if !client.IsInSegment(ctx, "enterprise_accounts", UserContext{UserID: "acme"}) {
+		t.Error("Expected included user to be a member")
+	}
+	if client.IsInSegment(ctx, "enterprise_accounts", UserContext{UserID: "other"}) {
+		t.Error("Expected other user not to be a member")
+	}
+	if client.IsInSegment(ctx, "unknown", UserContext{UserID: "acme"}) {
+		t.Error("Expected unknown segment not to match")
+	}
+	if calls := atomic.LoadInt32(&fetches); calls != 1 {
+		t.Errorf("Expected segments to be downloaded once, got %d", calls)
+	}
+
+	mock := NewMockClient()
+	mock.SetSegmentMembership("beta_users", true)
+	if !mock.IsInSegment(ctx, "beta_users", UserContext{UserID: "u1"}) {
+		t.Error("Expected mock segment membership")
+	}
+}
+
+func TestSegmentDownloadFailures(t *testing.T) {
+	var fetches, failing int32
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(SegmentsResponse{
+			Version:  "1",
+			Segments: map[string]SegmentDefinition{"beta_users": {Included: []string{"u1"}}},
+		})
+	}, func(config *Config) {
+		config.CacheConfig.TTL = 10 * time.Millisecond
+	})
+
+	ctx := context.Background()
+	user := UserContext{UserID: "u1"}
+
+	// Failed downloads are not retried on every call
+	atomic.StoreInt32(&failing, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client.IsInSegment(ctx, "beta_users", user)
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("Expected a single download attempt, got %d", got)
+	}
+
+	// Once loaded, expired segments are refreshed in the background
+	client.evaluator.segmentsMutex.Lock()
+	client.evaluator.segmentsRetryAt = time.Time{}
+	client.evaluator.segmentsMutex.Unlock()
+	atomic.StoreInt32(&failing, 0)
+	if !client.IsInSegment(ctx, "beta_users", user) {
+		t.Fatal("Expected member after successful download")
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.StoreInt32(&failing, 1)
+	if !client.IsInSegment(ctx, "beta_users", user) {
+		t.Error("Expected cached segments to be served while refreshing")
+	}
+}
+
+func TestPrerequisites(t *testing.T) {
+	onOff := []Variation{{Key: "on", Value: true}, {Key: "off", Value: false}}
+	flag := func(key string, enabled bool, serve string, prerequisites ...Prerequisite) FlagDefinition {
+		return FlagDefinition{
+			Key:           key,
+			Enabled:       enabled,
+			Variations:    onOff,
+			OffVariation:  "off",
+			Prerequisites: prerequisites,
+			Fallthrough:   Rollout{Variation: serve},
+		}
+	}
+
+	flags := map[string]FlagDefinition{
+		"new_api":      flag("new_api", true, "on"),
+		"legacy_api":   flag("legacy_api", false, "on"),
+		"new_checkout": flag("new_checkout", true, "on", Prerequisite{Key: "new_api", Variation: "on"}),
+		"one_click":    flag("one_click", true, "on", Prerequisite{Key: "new_checkout", Variation: "on"}, Prerequisite{Key: "new_api", Variation: "on"}),
+		"old_checkout": flag("old_checkout", true, "on", Prerequisite{Key: "legacy_api", Variation: "on"}),
+		"orphan":       flag("orphan", true, "on", Prerequisite{Key: "missing", Variation: "on"}),
+		"cycle_a":      flag("cycle_a", true, "on", Prerequisite{Key: "cycle_b", Variation: "on"}),
+		"cycle_b":      flag("cycle_b", true, "on", Prerequisite{Key: "cycle_a", Variation: "on"}),
+	}
+
+	var lookups int
+	engine := NewRulesEngine(NewNoOpLogger())
+	engine.flags = func(key string) (FlagDefinition, bool) {
+		lookups++
+		def, ok := flags[key]
+		return def, ok
+	}
+	user := UserContext{UserID: "u1"}
+
+	t.Run("met", func(t *testing.T) {
+		lookups = 0
+		result := engine.EvaluateFlag(flags["one_click"], false, user)
+		if result.Value != true || result.Reason != ReasonDefault {
+			t.Errorf("Expected prerequisites to pass, got %v (%s)", result.Value, result.Reason)
+		}
+		if lookups != 2 {
+			t.Errorf("Expected shared prerequisite to be evaluated once, got %d lookups", lookups)
+		}
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		result := engine.EvaluateFlag(flags["old_checkout"], true, user)
+		if result.Value != false || result.Reason != ReasonPrerequisiteFailed || result.RuleID != "prerequisite:legacy_api" {
+			t.Errorf("Expected disabled prerequisite to fail, got %v (%s, %s)", result.Value, result.Reason, result.RuleID)
+		}
+
+		result = engine.EvaluateFlag(flags["orphan"], true, user)
+		if result.Reason != ReasonPrerequisiteFailed || result.RuleID != "prerequisite:missing" {
+			t.Errorf("Expected missing prerequisite to fail, got %s, %s", result.Reason, result.RuleID)
+		}
+	})
+
+	t.Run("cycle", func(t *testing.T) {
+		result := engine.EvaluateFlag(flags["cycle_a"], true, user)
+		if result.Value != false || result.Reason != ReasonPrerequisiteFailed || result.RuleID != "prerequisite:cycle_b" {
+			t.Errorf("Expected cycle to fail the prerequisite, got %v (%s, %s)", result.Value, result.Reason, result.RuleID)
+		}
+	})
+}
+
+func TestKeylessPrerequisiteCycle(t *testing.T) {
+	// Flags identified only by their map keys, as some rulesets are served
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "1", "flags": {
+			"a": {"enabled": true, "variations": [{"key": "on", "value": true}], "prerequisites": [{"key": "b", "variation": "on"}], "fallthrough": {"variation": "on"}},
+			"b": {"enabled": true, "variations": [{"key": "on", "value": true}], "prerequisites": [{"key": "a", "variation": "on"}], "fallthrough": {"variation": "on"}}
+		}}`))
+	}, func(config *Config) {
+		config.LocalEvaluationConfig.Enabled = true
+	})
+
+	result := client.EvaluateFlag(context.Background(), "a", false, UserContext{UserID: "u1"})
+	if result.Value != false || result.Reason != ReasonPrerequisiteFailed {
+		t.Errorf("Expected cycle to fail the prerequisite, got %v (%s)", result.Value, result.Reason)
+	}
+
+	if def, _ := client.cacheManager.GetFlagDefinition("a"); def.Key != "a" {
+		t.Errorf("Expected flag key to be filled in from the ruleset, got %q", def.Key)
+	}
+}
+
+func TestKeylessRulesetBucketing(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "1",
+			"flags": {
+				"x": {"enabled": true, "variations": [{"key": "a", "value": "a"}, {"key": "b", "value": "b"}], "fallthrough": {"weights": [{"variation": "a", "weight": 50}, {"variation": "b", "weight": 50}]}},
+				"y": {"enabled": true, "variations": [{"key": "a", "value": "a"}, {"key": "b", "value": "b"}], "fallthrough": {"weights": [{"variation": "a", "weight": 50}, {"variation": "b", "weight": 50}]}}
+			},
+			"gates": {
+				"g": {"enabled": true, "rules": [{"id": "half", "conditions": [], "percentage": 50}]}
+			}
+		}`))
+	}, func(config *Config) {
+		config.LocalEvaluationConfig.Enabled = true
+	})
+	ctx := context.Background()
+
+	// Users are bucketed on salt:flag_key:user_id, so flags split independently
+	for i := 0; i < 100; i++ {
+		user := UserContext{UserID: "user-" + strconv.Itoa(i)}
+		for _, flagKey := range []string{"x", "y"} {
+			want := "b"
+			if bucketPercentage(Bucket("", flagKey, user.UserID)) < 50 {
+				want = "a"
+			}
+			if got := client.EvaluateFlagString(ctx, flagKey, "", user); got != want {
+				t.Fatalf("Expected %s for %s on %s, got %s", want, user.UserID, flagKey, got)
+			}
+		}
+
+		want := bucketPercentage(Bucket("", "g", user.UserID)) < 50
+		if got := client.EvaluateGate(ctx, "g", user); got != want {
+			t.Fatalf("Expected gate %v for %s, got %v", want, user.UserID, got)
+		}
+	}
+}
+
+func TestLocalAllFlags(t *testing.T) {
+	client, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(testRuleset())
+	}, func(config *Config) {
+		config.LocalEvaluationConfig.Enabled = true
+	})
+
+	state, err := client.AllFlags(context.Background(), UserContext{UserID: "u1", Email: "a@variably.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(state.Flags) != 3 || len(state.Gates) != 1 {
+		t.Errorf("Expected every flag and gate in the ruleset, got %d flags and %d gates", len(state.Flags), len(state.Gates))
+	}
+	if value, _ := state.Value("theme"); value != "light" || !state.Gate("admin") {
+		t.Errorf("Unexpected state: %+v", state)
+	}
+}
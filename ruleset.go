@@ -0,0 +1,186 @@
+package variably
+
+import (
+	"time"
+)
+
+// Ruleset is the full set of flag and gate definitions for an environment,
+// downloaded by server-side SDKs so that evaluation can happen in-process
+type Ruleset struct {
+	Environment string                       `json:"environment"`
+	Version     string                       `json:"version"`
+	Flags       map[string]FlagDefinition    `json:"flags"`
+	Gates       map[string]GateDefinition    `json:"gates"`
+	Segments    map[string]SegmentDefinition `json:"segments,omitempty"`
+	UpdatedAt   time.Time                    `json:"updated_at"`
+}
+
+// FlagDefinition describes how a feature flag is evaluated locally
+type FlagDefinition struct {
+	Key           string          `json:"key"`
+	Enabled       bool            `json:"enabled"`
+	Salt          string          `json:"salt,omitempty"`
+	BucketBy      string          `json:"bucket_by,omitempty"`
+	Variations    []Variation     `json:"variations"`
+	OffVariation  string          `json:"off_variation,omitempty"`
+	Prerequisites []Prerequisite  `json:"prerequisites,omitempty"`
+	Rules         []TargetingRule `json:"rules,omitempty"`
+	Fallthrough   Rollout         `json:"fallthrough"`
+}
+
+// Prerequisite requires another flag to serve a specific variation before
+// this flag's rules are evaluated
+type Prerequisite struct {
+	Key       string `json:"key"`
+	Variation string `json:"variation"`
+}
+
+// Variation is a named value a flag can serve
+type Variation struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+// TargetingRule serves a rollout to users matching all of its conditions
+type TargetingRule struct {
+	ID         string      `json:"id"`
+	Conditions []Condition `json:"conditions"`
+	Rollout    Rollout     `json:"rollout"`
+}
+
+// Condition matches a single user attribute against a set of values
+type Condition struct {
+	Attribute string        `json:"attribute"`
+	Operator  string        `json:"operator"`
+	Values    []interface{} `json:"values"`
+	Negate    bool          `json:"negate,omitempty"`
+}
+
+// Rollout either serves a fixed variation or splits users across
+// weighted variations. Weights are percentages and should sum to 100.
+type Rollout struct {
+	Variation string              `json:"variation,omitempty"`
+	Weights   []WeightedVariation `json:"weights,omitempty"`
+}
+
+// WeightedVariation assigns a percentage of users to a variation
+type WeightedVariation struct {
+	Variation string  `json:"variation"`
+	Weight    float64 `json:"weight"`
+}
+
+// GateDefinition describes how a feature gate is evaluated locally
+type GateDefinition struct {
+	Key      string     `json:"key"`
+	Enabled  bool       `json:"enabled"`
+	Salt     string     `json:"salt,omitempty"`
+	BucketBy string     `json:"bucket_by,omitempty"`
+	Rules    []GateRule `json:"rules,omitempty"`
+}
+
+// GateRule grants access to a percentage of users matching all of its conditions
+type GateRule struct {
+	ID         string      `json:"id"`
+	Conditions []Condition `json:"conditions"`
+	Percentage float64     `json:"percentage"`
+}
+
+// SegmentDefinition is a named, reusable group of users. Excluded users are
+// never members; included users always are; anyone else is a member if they
+// match any of the segment's rules.
+type SegmentDefinition struct {
+	Key      string        `json:"key"`
+	Included []string      `json:"included,omitempty"`
+	Excluded []string      `json:"excluded,omitempty"`
+	Rules    []SegmentRule `json:"rules,omitempty"`
+}
+
+// SegmentRule adds users matching all of its conditions to a segment
+type SegmentRule struct {
+	ID         string      `json:"id"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Supported condition operators
+const (
+	OperatorIn         = "in"
+	OperatorNotIn      = "not_in"
+	OperatorContains   = "contains"
+	OperatorStartsWith = "starts_with"
+	OperatorEndsWith   = "ends_with"
+	OperatorMatches    = "matches"
+	OperatorGreater    = "gt"
+	OperatorGreaterEq  = "gte"
+	OperatorLess       = "lt"
+	OperatorLessEq     = "lte"
+	OperatorSemverEq   = "semver_eq"
+	OperatorSemverGt   = "semver_gt"
+	OperatorSemverLt   = "semver_lt"
+
+	// OperatorInSegment matches users in any of the segments listed in Values.
+	// The condition's Attribute is ignored.
+	OperatorInSegment = "in_segment"
+)
+
+// normalizeKeys fills in flag and gate keys the server left out from the keys
+// they are listed under. Cycle detection and bucketing both rely on Key.
+func (r *Ruleset) normalizeKeys() {
+	for key, def := range r.Flags {
+		if def.Key == "" {
+			def.Key = key
+			r.Flags[key] = def
+		}
+	}
+	for key, def := range r.Gates {
+		if def.Key == "" {
+			def.Key = key
+			r.Gates[key] = def
+		}
+	}
+}
+
+// variation returns the variation with the given key
+func (f *FlagDefinition) variation(key string) (Variation, bool) {
+	for _, v := range f.Variations {
+		if v.Key == key {
+			return v, true
+		}
+	}
+	return Variation{}, false
+}
+
+// segment is a SegmentDefinition with its include and exclude lists indexed
+// for constant-time lookup, so large allow-lists stay cheap to evaluate
+type segment struct {
+	SegmentDefinition
+	included map[string]struct{}
+	excluded map[string]struct{}
+}
+
+// newSegment indexes a segment definition
+func newSegment(def SegmentDefinition) *segment {
+	s := &segment{
+		SegmentDefinition: def,
+		included:          make(map[string]struct{}, len(def.Included)),
+		excluded:          make(map[string]struct{}, len(def.Excluded)),
+	}
+	for _, userID := range def.Included {
+		s.included[userID] = struct{}{}
+	}
+	for _, userID := range def.Excluded {
+		s.excluded[userID] = struct{}{}
+	}
+	return s
+}
+
+// indexSegments indexes every segment definition by key
+func indexSegments(defs map[string]SegmentDefinition) map[string]*segment {
+	segments := make(map[string]*segment, len(defs))
+	for key, def := range defs {
+		if def.Key == "" {
+			def.Key = key
+		}
+		segments[key] = newSegment(def)
+	}
+	return segments
+}
@@ -0,0 +1,95 @@
+package variably
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flightGroup coalesces concurrent evaluations with the same key so that
+// only one upstream request is made and every caller shares its result
+type flightGroup struct {
+	mutex   sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is an in-progress evaluation shared by one or more callers
+type flight struct {
+	done    chan struct{}
+	result  FlagResult
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// newFlightGroup creates an empty flight group
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do runs fn for the key unless a call for the same key is already in flight,
+// in which case it waits for that call's result instead. fn runs with its own
+// context so that one caller giving up does not fail the others; it is
+// cancelled only once every caller has stopped waiting. That context carries
+// the deadline of the caller that started the call, so that retries are
+// budgeted against it. Callers whose context ends first get a result with the
+// context's error.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) FlagResult) (FlagResult, bool) {
+	g.mutex.Lock()
+	f, shared := g.flights[key]
+	if shared {
+		f.waiters++
+	} else {
+		var flightCtx context.Context
+		var cancel context.CancelFunc
+		if deadline, ok := ctx.Deadline(); ok {
+			flightCtx, cancel = context.WithDeadline(context.Background(), deadline)
+		} else {
+			flightCtx, cancel = context.WithCancel(context.Background())
+		}
+		f = &flight{
+			done:    make(chan struct{}),
+			waiters: 1,
+			cancel:  cancel,
+		}
+		g.flights[key] = f
+
+		go func() {
+			f.result = fn(flightCtx)
+
+			g.mutex.Lock()
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+			g.mutex.Unlock()
+
+			close(f.done)
+			cancel()
+		}()
+	}
+	g.mutex.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, shared
+	case <-ctx.Done():
+		g.mutex.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// Nobody wants the result any more; later callers start afresh
+			f.cancel()
+			if g.flights[key] == f {
+				delete(g.flights, key)
+			}
+		}
+		g.mutex.Unlock()
+
+		return FlagResult{
+			Reason:      ReasonError,
+			ErrorCode:   ErrorCodeNetwork,
+			Error:       ctx.Err(),
+			EvaluatedAt: time.Now(),
+		}, shared
+	}
+}
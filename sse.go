@@ -0,0 +1,181 @@
+package variably
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// streamPath is the Server-Sent Events endpoint for flag changes
+const streamPath = "/api/v1/sdk/stream"
+
+// maxSSEEvent bounds the size of a single event, and so of any line in it
+const maxSSEEvent = 1 << 20
+
+// errSSEEventTooLarge is returned for events or lines over maxSSEEvent
+var errSSEEventTooLarge = errors.New("SSE event too large")
+
+// sseReader parses a text/event-stream body
+type sseReader struct {
+	reader *bufio.Reader
+	body   io.Closer
+
+	// retry is the reconnection delay requested by the server, if any
+	retry time.Duration
+}
+
+// newSSEReader creates a reader for an event stream
+func newSSEReader(r io.Reader) *sseReader {
+	reader := &sseReader{reader: bufio.NewReader(r)}
+	if closer, ok := r.(io.Closer); ok {
+		reader.body = closer
+	}
+	return reader
+}
+
+// Close closes the underlying stream
+func (r *sseReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// RetryDelay returns the reconnection delay requested by the server, if any
+func (r *sseReader) RetryDelay() time.Duration {
+	return r.retry
+}
+
+// Next returns the next event, skipping comments used as heartbeats. Events
+// without an id leave the last event ID unchanged, so ID is empty for them.
+func (r *sseReader) Next() (streamEvent, error) {
+	var event streamEvent
+	var data []string
+	size := 0
+
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return streamEvent{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		// A blank line dispatches the event
+		if line == "" {
+			if len(data) == 0 {
+				event = streamEvent{}
+				size = 0
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			if event.Event == "" {
+				event.Event = "message"
+			}
+			return event, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		size += len(line)
+		if size > maxSSEEvent {
+			return streamEvent{}, errSSEEventTooLarge
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "id":
+			event.ID = value
+		case "event":
+			event.Event = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine reads a line, failing rather than buffering one over maxSSEEvent
+func (r *sseReader) readLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxSSEEvent {
+			return "", errSSEEventTooLarge
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
+}
+
+// OpenStream connects to the flag change stream on the preferred healthy
+// endpoint, resuming after lastEventID if set. The caller must close the body.
+func (c *HTTPClient) OpenStream(ctx context.Context, lastEventID string) (io.ReadCloser, error) {
+	// The stream stays open indefinitely, so the client timeout must not apply
+	client := &http.Client{Transport: c.client.Transport}
+	apiKey, _ := c.keys()
+
+	var lastErr error
+	for _, endpoint := range c.endpoints.candidates() {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint+streamPath, nil)
+		if err != nil {
+			return nil, NewNetworkError("Failed to create request", 0, endpoint+streamPath, err)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Cache-Control", "no-cache")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		c.setRequestHeaders(req, apiKey, nil)
+
+		c.logger.Debug("Opening flag stream", "endpoint", endpoint, "last_event_id", lastEventID)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = NewNetworkError("Stream connection failed", 0, req.URL.String(), err)
+			if ctx.Err() != nil {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			lastErr = c.handleHTTPError(resp.StatusCode, resp.Header, body, req.URL.String())
+			if !isEndpointFailure(lastErr) {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+			resp.Body.Close()
+			return nil, NewNetworkError(fmt.Sprintf("unexpected stream content type %q", contentType), resp.StatusCode, req.URL.String(), nil)
+		}
+
+		return resp.Body, nil
+	}
+
+	return nil, lastErr
+}
@@ -0,0 +1,92 @@
+package variably
+
+import "strings"
+
+// subscriber is a callback registered by one Subscribe call
+type subscriber struct {
+	callback UpdateCallback
+
+	// Flag keys and patterns it is still registered under, guarded by subMutex
+	keys []string
+}
+
+// subscriptionMatches reports whether a subscribed key or pattern covers a
+// flag. A pattern ending in "*" matches every flag with the preceding prefix.
+func subscriptionMatches(pattern, flagKey string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(flagKey, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == flagKey
+}
+
+// matchingSubscribers returns the subscribers to a flag, each once even if
+// several of its keys and patterns match
+func (c *VariablyClient) matchingSubscribers(flagKey string) []*subscriber {
+	c.subMutex.RLock()
+	defer c.subMutex.RUnlock()
+
+	var subscribers []*subscriber
+	seen := make(map[*subscriber]bool)
+	for pattern, subs := range c.subscriptions {
+		if !subscriptionMatches(pattern, flagKey) {
+			continue
+		}
+		for _, sub := range subs {
+			if !seen[sub] {
+				seen[sub] = true
+				subscribers = append(subscribers, sub)
+			}
+		}
+	}
+	return subscribers
+}
+
+// removeSubscriber removes a subscriber from every key it is registered under
+func (c *VariablyClient) removeSubscriber(sub *subscriber) {
+	c.subMutex.Lock()
+	defer c.subMutex.Unlock()
+
+	if len(sub.keys) == 0 {
+		return
+	}
+
+	for _, flagKey := range sub.keys {
+		subs := c.subscriptions[flagKey]
+		for i, other := range subs {
+			if other == sub {
+				subs = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+		if len(subs) == 0 {
+			delete(c.subscriptions, flagKey)
+		} else {
+			c.subscriptions[flagKey] = subs
+		}
+	}
+	c.logger.Info("Unsubscribed from flag updates", "flags", sub.keys)
+
+	sub.keys = nil
+	c.metrics.RecordUnsubscribed()
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// removeString returns values without value
+func removeString(values []string, value string) []string {
+	result := values[:0:0]
+	for _, v := range values {
+		if v != value {
+			result = append(result, v)
+		}
+	}
+	return result
+}
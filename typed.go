@@ -0,0 +1,242 @@
+package variably
+
+import (
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// EvaluationDetail holds a typed flag value together with the full evaluation result
+type EvaluationDetail[T any] struct {
+	Value  T
+	Result FlagResult
+}
+
+// Evaluate evaluates a flag and converts its value to T, returning defaultValue
+// if evaluation fails or the value cannot be converted.
+//
+// JSON objects and arrays decode into structs, maps and slices. Numbers convert
+// to any integer or float width as long as they fit. Durations accept strings
+// such as "1.5s" or a number of milliseconds, and types implementing
+// encoding.TextUnmarshaler are decoded from strings.
+func Evaluate[T any](ctx context.Context, client Client, flagKey string, defaultValue T, userContext UserContext) T {
+	return EvaluateDetail(ctx, client, flagKey, defaultValue, userContext).Value
+}
+
+// EvaluateDetail is like Evaluate but also returns the evaluation metadata. When
+// the value cannot be converted, Result has ReasonError and ErrorCodeTypeMismatch
+// and Result.Error describes the mismatch.
+func EvaluateDetail[T any](ctx context.Context, client Client, flagKey string, defaultValue T, userContext UserContext) EvaluationDetail[T] {
+	result := client.EvaluateFlag(ctx, flagKey, defaultValue, userContext)
+	if result.Error != nil {
+		return EvaluationDetail[T]{Value: defaultValue, Result: result}
+	}
+
+	value, err := coerce[T](result.Value)
+	if err != nil {
+		result.Value = defaultValue
+		result.Reason = ReasonError
+		result.ErrorCode = ErrorCodeTypeMismatch
+		result.Error = err
+		return EvaluationDetail[T]{Value: defaultValue, Result: result}
+	}
+
+	return EvaluationDetail[T]{Value: value, Result: result}
+}
+
+// coerce converts a raw flag value to T
+func coerce[T any](value interface{}) (T, error) {
+	var out T
+	if err := convertValue(value, reflect.ValueOf(&out).Elem()); err != nil {
+		var zero T
+		return zero, err
+	}
+	return out, nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// convertValue stores value into out, converting between compatible representations
+func convertValue(value interface{}, out reflect.Value) error {
+	target := out.Type()
+
+	if value == nil {
+		switch target.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			out.Set(reflect.Zero(target))
+			return nil
+		}
+		return fmt.Errorf("cannot convert nil flag value to %s", target)
+	}
+
+	source := reflect.ValueOf(value)
+	if source.Type().AssignableTo(target) {
+		out.Set(source)
+		return nil
+	}
+
+	// Custom types decode themselves from strings
+	if s, ok := value.(string); ok && reflect.PtrTo(target).Implements(textUnmarshalerType) {
+		if err := out.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("cannot decode %q as %s: %w", s, target, err)
+		}
+		return nil
+	}
+
+	if target == durationType {
+		return convertDuration(value, out)
+	}
+
+	switch target.Kind() {
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			out.SetBool(v)
+			return nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				out.SetBool(b)
+				return nil
+			}
+		}
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			out.SetString(s)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toInt64(value)
+		if !ok || out.OverflowInt(n) {
+			break
+		}
+		out.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := toUint64(value)
+		if !ok || out.OverflowUint(n) {
+			break
+		}
+		out.SetUint(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if _, isString := value.(string); isString {
+			break
+		}
+		f, ok := toFloat(value)
+		if !ok || out.OverflowFloat(f) {
+			break
+		}
+		out.SetFloat(f)
+		return nil
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array, reflect.Ptr, reflect.Interface:
+		return convertJSON(value, out)
+	}
+
+	return fmt.Errorf("cannot convert flag value %v (%T) to %s", value, value, target)
+}
+
+// convertDuration accepts duration strings or a number of milliseconds
+func convertDuration(value interface{}, out reflect.Value) error {
+	if s, ok := value.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("cannot decode %q as duration: %w", s, err)
+		}
+		out.SetInt(int64(d))
+		return nil
+	}
+
+	ms, ok := toFloat(value)
+	if !ok || math.Abs(ms) > float64(math.MaxInt64/int64(time.Millisecond)) {
+		return fmt.Errorf("cannot convert flag value %v (%T) to duration", value, value)
+	}
+	out.SetInt(int64(ms * float64(time.Millisecond)))
+	return nil
+}
+
+// convertJSON decodes structured values by round-tripping them through JSON.
+// String values are treated as encoded JSON documents.
+func convertJSON(value interface{}, out reflect.Value) error {
+	var data []byte
+	if s, ok := value.(string); ok {
+		data = []byte(s)
+	} else {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("cannot encode flag value: %w", err)
+		}
+		data = encoded
+	}
+
+	if err := json.Unmarshal(data, out.Addr().Interface()); err != nil {
+		return fmt.Errorf("cannot decode flag value into %s: %w", out.Type(), err)
+	}
+	return nil
+}
+
+// toInt64 converts a numeric value to int64, rejecting fractional and out-of-range values
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint, uint8, uint16, uint32, uint64:
+		u, _ := toUint64(v)
+		return int64(u), u <= math.MaxInt64
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case float32, float64:
+		f, _ := toFloat(v)
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	default:
+		return 0, false
+	}
+}
+
+// toUint64 converts a numeric value to uint64, rejecting negative, fractional and out-of-range values
+func toUint64(value interface{}) (uint64, bool) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), true
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case json.Number:
+		n, err := strconv.ParseUint(v.String(), 10, 64)
+		return n, err == nil
+	case int, int8, int16, int32, int64, float32, float64:
+		if n, ok := toInt64(v); ok {
+			return uint64(n), n >= 0
+		}
+		if f, ok := toFloat(v); ok && f == math.Trunc(f) && f >= 0 && f < math.MaxUint64 {
+			return uint64(f), true
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+}
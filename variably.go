@@ -2,6 +2,7 @@ package variably
 
 import (
 	"context"
+	"math/rand"
 	"sync"
 	"time"
 )
@@ -16,9 +17,23 @@ type VariablyClient struct {
 	logger       Logger
 
 	// Real-time updates
-	subscriptions map[string][]UpdateCallback
+	subscriptions map[string][]*subscriber
+	watchers      map[string]map[chan struct{}]struct{}
 	subMutex      sync.RWMutex
 
+	// Last known value of each flag, used to notify only on changes
+	flagValues  map[string]interface{}
+	valuesMutex sync.Mutex
+
+	// Experiment exposures waiting to be sent, and closed once the sender has flushed them
+	exposures     chan Event
+	exposuresDone chan struct{}
+
+	// Ruleset reloads triggered by flag changes, coalesced while one runs
+	reloading     bool
+	reloadPending bool
+	reloadMutex   sync.Mutex
+
 	// Lifecycle
 	closed   bool
 	stopCh   chan struct{}
@@ -61,14 +76,27 @@ func NewClient(config *Config) (Client, error) {
 		cacheManager:  cacheManager,
 		metrics:       metrics,
 		logger:        logger,
-		subscriptions: make(map[string][]UpdateCallback),
+		subscriptions: make(map[string][]*subscriber),
+		watchers:      make(map[string]map[chan struct{}]struct{}),
+		flagValues:    make(map[string]interface{}),
+		exposures:     make(chan Event, exposureQueueSize),
+		exposuresDone: make(chan struct{}),
 		stopCh:        make(chan struct{}),
 	}
 
+	// Download the ruleset up front so local evaluation is ready on return
+	if config.LocalEvaluationConfig.Enabled {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		if err := evaluator.LoadRuleset(ctx); err != nil {
+			logger.Warn("Initial ruleset download failed, will retry in background", "error", err)
+		}
+		cancel()
+	}
+
 	// Start background tasks
 	client.startBackgroundTasks()
 
-	logger.Info("Variably client initialized", "environment", config.Environment, "base_url", config.BaseURL)
+	logger.Info("Variably client initialized", "environment", config.Environment, "endpoints", config.endpointURLs())
 
 	return client, nil
 }
@@ -191,6 +219,89 @@ func (c *VariablyClient) EvaluateGate(ctx context.Context, gateKey string, userC
 	return c.evaluator.EvaluateGate(ctx, gateKey, userContext)
 }
 
+// Segments
+
+// IsInSegment reports whether the user belongs to a segment
+func (c *VariablyClient) IsInSegment(ctx context.Context, segmentKey string, userContext UserContext) bool {
+	c.ensureNotClosed()
+	return c.evaluator.IsInSegment(ctx, segmentKey, userContext)
+}
+
+// Experiments
+
+// GetExperimentAssignment returns the user's variant for an experiment and queues
+// an exposure event whenever the user is in the experiment
+func (c *VariablyClient) GetExperimentAssignment(ctx context.Context, experimentKey string, userContext UserContext) (ExperimentAssignment, error) {
+	c.ensureNotClosed()
+
+	assignment, err := c.evaluator.GetExperimentAssignment(ctx, experimentKey, userContext)
+	if err != nil {
+		return assignment, err
+	}
+
+	if assignment.InExperiment {
+		c.recordExposure(assignment, userContext)
+	}
+
+	return assignment, nil
+}
+
+// exposureQueueSize bounds the exposures waiting to be tracked
+const exposureQueueSize = 1000
+
+// recordExposure queues an exposure event to be sent through Track in the
+// background. It never blocks the caller: exposures are tracked with their own
+// timeout, so a cancelled or short-lived request context cannot lose them. If
+// the queue is full the event is dropped, logged and counted in
+// Metrics.EventsDropped.
+func (c *VariablyClient) recordExposure(assignment ExperimentAssignment, userContext UserContext) {
+	if !c.config.EnableAnalytics {
+		c.logger.Debug("Analytics disabled, skipping experiment exposure")
+		return
+	}
+
+	event := Event{
+		Name:      ExposureEventName,
+		UserID:    userContext.UserID,
+		SessionID: userContext.SessionID,
+		Properties: map[string]interface{}{
+			"experiment_key": assignment.ExperimentKey,
+			"variant":        assignment.Variant,
+		},
+		Context:   userContext,
+		Timestamp: time.Now(),
+	}
+
+	select {
+	case c.exposures <- event:
+	default:
+		c.metrics.RecordEventDropped()
+		c.logger.Warn("Exposure queue full, dropping experiment exposure", "experiment_key", assignment.ExperimentKey, "variant", assignment.Variant)
+	}
+}
+
+// sendExposures tracks queued exposures until the client is closed, then
+// flushes whatever is left within a single timeout
+func (c *VariablyClient) sendExposures() {
+	defer close(c.exposuresDone)
+
+	for {
+		select {
+		case event := <-c.exposures:
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+			c.track(ctx, event)
+			cancel()
+		case <-c.stopCh:
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+			defer cancel()
+			for len(c.exposures) > 0 {
+				c.track(ctx, <-c.exposures)
+			}
+			return
+		}
+	}
+}
+
 // Batch Operations
 
 // EvaluateFlags evaluates multiple feature flags
@@ -205,12 +316,24 @@ func (c *VariablyClient) EvaluateGates(ctx context.Context, gateKeys []string, u
 	return c.evaluator.EvaluateGates(ctx, gateKeys, userContext)
 }
 
+// AllFlags evaluates every flag and gate for a user. The returned state
+// marshals to JSON suitable for bootstrapping client-side SDKs.
+func (c *VariablyClient) AllFlags(ctx context.Context, userContext UserContext) (FlagsState, error) {
+	c.ensureNotClosed()
+	return c.evaluator.AllFlags(ctx, userContext)
+}
+
 // Event Tracking
 
 // Track tracks a single analytics event
 func (c *VariablyClient) Track(ctx context.Context, event Event) error {
 	c.ensureNotClosed()
-	
+	return c.track(ctx, event)
+}
+
+// track sends an event without checking that the client is open, so queued
+// exposures can still be flushed while it closes
+func (c *VariablyClient) track(ctx context.Context, event Event) error {
 	if !c.config.EnableAnalytics {
 		c.logger.Debug("Analytics disabled, skipping event tracking")
 		return nil
@@ -265,26 +388,39 @@ func (c *VariablyClient) TrackBatch(ctx context.Context, events []Event) error {
 
 // Real-time Updates
 
-// Subscribe subscribes to real-time flag updates
-func (c *VariablyClient) Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) error {
+// Subscribe registers a callback for changes to the given flags. A key ending
+// in "*" matches every flag with that prefix, so "checkout.*" matches all flags
+// under "checkout." and "*" matches every flag. Call Unsubscribe on the
+// returned Subscription to remove just this callback.
+func (c *VariablyClient) Subscribe(ctx context.Context, flagKeys []string, callback UpdateCallback) (*Subscription, error) {
 	c.ensureNotClosed()
 	
-	if !c.config.EnableRealTimeSync {
-		return NewConfigError("Real-time sync is disabled", "EnableRealTimeSync", nil)
+	if !c.config.EnableRealTimeSync && !c.config.PollingConfig.Enabled {
+		return nil, NewConfigError("Real-time sync and polling are disabled", "EnableRealTimeSync", nil)
+	}
+	if len(flagKeys) == 0 {
+		return nil, NewValidationError("At least one flag key or pattern is required", "flag_keys", nil)
 	}
 	
-	c.subMutex.Lock()
-	defer c.subMutex.Unlock()
+	sub := &subscriber{callback: callback}
 	
+	c.subMutex.Lock()
 	for _, flagKey := range flagKeys {
-		c.subscriptions[flagKey] = append(c.subscriptions[flagKey], callback)
+		if containsString(sub.keys, flagKey) {
+			continue
+		}
+		sub.keys = append(sub.keys, flagKey)
+		c.subscriptions[flagKey] = append(c.subscriptions[flagKey], sub)
 	}
+	c.subMutex.Unlock()
+	c.metrics.RecordSubscribed()
 	
 	c.logger.Info("Subscribed to flag updates", "flags", flagKeys)
-	return nil
+	return &Subscription{unsubscribe: func() { c.removeSubscriber(sub) }}, nil
 }
 
-// Unsubscribe unsubscribes from real-time flag updates
+// Unsubscribe removes every callback subscribed to the given keys, including
+// those registered by other callers. Prefer Subscription.Unsubscribe.
 func (c *VariablyClient) Unsubscribe(flagKeys []string) error {
 	c.ensureNotClosed()
 	
@@ -292,6 +428,12 @@ func (c *VariablyClient) Unsubscribe(flagKeys []string) error {
 	defer c.subMutex.Unlock()
 	
 	for _, flagKey := range flagKeys {
+		for _, sub := range c.subscriptions[flagKey] {
+			sub.keys = removeString(sub.keys, flagKey)
+			if len(sub.keys) == 0 {
+				c.metrics.RecordUnsubscribed()
+			}
+		}
 		delete(c.subscriptions, flagKey)
 	}
 	
@@ -320,19 +462,32 @@ func (c *VariablyClient) GetMetrics() Metrics {
 	return c.metrics.GetMetrics()
 }
 
+// Credentials
+
+// RotateAPIKey switches to a new API key at runtime. Requests the API rejects
+// as unauthorized are retried with the previous key until the new key has
+// been accepted once, after which the previous key is retired.
+func (c *VariablyClient) RotateAPIKey(newKey string) error {
+	c.ensureNotClosed()
+	return c.httpClient.RotateAPIKey(newKey)
+}
+
 // Lifecycle
 
 // Close closes the client and cleans up resources
 func (c *VariablyClient) Close() error {
 	c.closeMux.Lock()
-	defer c.closeMux.Unlock()
-	
 	if c.closed {
+		c.closeMux.Unlock()
 		return nil
 	}
 	
 	c.closed = true
 	close(c.stopCh)
+	c.closeMux.Unlock()
+
+	// Wait for queued exposures to be sent; the flush is bounded by the timeout
+	<-c.exposuresDone
 	
 	c.logger.Info("Variably client closed")
 	return nil
@@ -354,46 +509,116 @@ func (c *VariablyClient) ensureNotClosed() {
 func (c *VariablyClient) startBackgroundTasks() {
 	// Start cache cleanup
 	go c.cacheManager.StartCleanup(c.stopCh)
+
+	// Send experiment exposures off the caller's goroutine
+	go c.sendExposures()
 	
+	// Stream flag changes to subscribers
+	if c.config.EnableRealTimeSync {
+		go c.startStreaming()
+	}
+
 	// Start polling for updates if enabled
 	if c.config.PollingConfig.Enabled {
 		go c.startPolling()
 	}
+
+	// Probe failed endpoints so requests fail back once they recover
+	if len(c.config.Endpoints) > 1 {
+		go c.httpClient.StartHealthProbes(c.stopCh)
+	}
+
+	// Keep the local evaluation ruleset up to date
+	if c.config.LocalEvaluationConfig.Enabled {
+		go c.startRulesetRefresh()
+	}
 }
 
-// startPolling starts polling for flag updates
-func (c *VariablyClient) startPolling() {
-	ticker := time.NewTicker(c.config.PollingConfig.Interval)
+// startRulesetRefresh periodically downloads the latest ruleset
+func (c *VariablyClient) startRulesetRefresh() {
+	ticker := time.NewTicker(c.config.LocalEvaluationConfig.RefreshInterval)
 	defer ticker.Stop()
-	
+
 	for {
 		select {
 		case <-ticker.C:
-			c.pollForUpdates()
+			c.reloadRuleset()
 		case <-c.stopCh:
 			return
 		}
 	}
 }
 
-// pollForUpdates polls the API for flag updates
-func (c *VariablyClient) pollForUpdates() {
-	// This is a simplified implementation
-	// In a real implementation, you would call an API endpoint
-	// that returns flag changes since the last poll
-	c.logger.Debug("Polling for flag updates")
-	
-	// For now, just clear cache to force refresh
-	// This ensures flags are re-evaluated on next access
-	if c.config.PollingConfig.Enabled {
-		// Only refresh a subset of cache based on subscriptions
-		c.subMutex.RLock()
-		hasSubscriptions := len(c.subscriptions) > 0
-		c.subMutex.RUnlock()
-		
-		if hasSubscriptions {
-			// In production, you would selectively refresh only subscribed flags
-			c.logger.Debug("Refreshing cache due to polling")
+// startPolling polls for flag changes, waiting Interval plus a random jitter
+// between polls so that many clients do not poll in lockstep
+func (c *VariablyClient) startPolling() {
+	var version string
+	for {
+		delay := c.config.PollingConfig.Interval
+		if jitter := c.config.PollingConfig.Jitter; jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(jitter)))
 		}
+
+		select {
+		case <-time.After(delay):
+			version = c.pollForUpdates(version)
+		case <-c.stopCh:
+			return
+		}
+	}
+}
+
+// pollForUpdates fetches flag changes since the given version and applies
+// them, returning the version to poll from next. The first poll only records
+// a baseline. Later polls apply changed values to the cache and notify
+// subscribers of them; flags whose value did not change may have changed
+// targeting, so their cached values are dropped.
+func (c *VariablyClient) pollForUpdates(version string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+
+	c.logger.Debug("Polling for flag updates", "since", version)
+
+	resp, err := c.httpClient.FetchChanges(ctx, c.config.Environment, version)
+	if err != nil {
+		c.logger.Warn("Failed to poll for flag updates", "error", err)
+		return version
+	}
+
+	// Without a new version, keep polling from the last one
+	next := resp.Version
+	if next == "" {
+		next = version
 	}
-}
\ No newline at end of file
+
+	if version == "" {
+		for _, change := range resp.Changes {
+			c.recordFlagValue(change)
+		}
+		return next
+	}
+
+	if len(resp.Changes) == 0 {
+		return next
+	}
+
+	keys := make([]string, len(resp.Changes))
+	var changed []FlagUpdateMessage
+	for i, change := range resp.Changes {
+		keys[i] = change.FlagKey
+		if c.recordFlagValue(change) {
+			c.evaluator.ApplyFlagValue(change)
+			changed = append(changed, change)
+		} else {
+			c.evaluator.InvalidateFlag(change.FlagKey)
+		}
+	}
+	c.flagsChanged(keys)
+
+	for _, change := range changed {
+		c.notifySubscribers(change)
+	}
+
+	c.logger.Debug("Applied polled flag changes", "changes", len(resp.Changes), "version", next)
+	return next
+}
@@ -0,0 +1,115 @@
+package variably
+
+import (
+	"context"
+	"reflect"
+)
+
+// Watch evaluates a flag for a user and emits the result, then re-evaluates on
+// every upstream change to the flag and emits again only when the user's value
+// differs. Changes arrive through real-time sync or polling. The channel is
+// closed when ctx is cancelled or the client is closed.
+func (c *VariablyClient) Watch(ctx context.Context, flagKey string, userContext UserContext) <-chan FlagResult {
+	c.ensureNotClosed()
+
+	if !c.config.EnableRealTimeSync && !c.config.PollingConfig.Enabled {
+		c.logger.Warn("Real-time sync and polling are disabled, watched flag will not change", "flag_key", flagKey)
+	}
+
+	changed := make(chan struct{}, 1)
+	c.subMutex.Lock()
+	if c.watchers[flagKey] == nil {
+		c.watchers[flagKey] = make(map[chan struct{}]struct{})
+	}
+	c.watchers[flagKey][changed] = struct{}{}
+	c.subMutex.Unlock()
+	c.metrics.RecordSubscribed()
+
+	results := make(chan FlagResult, 1)
+	go func() {
+		defer func() {
+			c.subMutex.Lock()
+			delete(c.watchers[flagKey], changed)
+			if len(c.watchers[flagKey]) == 0 {
+				delete(c.watchers, flagKey)
+			}
+			c.subMutex.Unlock()
+			c.metrics.RecordUnsubscribed()
+		}()
+
+		watchFlag(ctx, c.stopCh, changed, func() FlagResult {
+			return c.evaluator.EvaluateFlag(ctx, flagKey, nil, userContext)
+		}, results)
+	}()
+
+	return results
+}
+
+// signalWatchers tells the watchers of changed flags to re-evaluate. With
+// local evaluation every watcher is woken, since a change to a prerequisite
+// flag or a segment can change the value of flags that depend on it, and
+// re-evaluating against the ruleset is cheap.
+func (c *VariablyClient) signalWatchers(flagKeys []string) {
+	c.subMutex.RLock()
+	defer c.subMutex.RUnlock()
+
+	if c.config.LocalEvaluationConfig.Enabled {
+		for _, watchers := range c.watchers {
+			for changed := range watchers {
+				signal(changed)
+			}
+		}
+		return
+	}
+
+	for _, flagKey := range flagKeys {
+		for changed := range c.watchers[flagKey] {
+			signal(changed)
+		}
+	}
+}
+
+// signal wakes a watcher without blocking; pending signals are coalesced
+func signal(changed chan struct{}) {
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// watchFlag emits the first evaluation, then re-evaluates whenever changed
+// fires and emits results whose value differs from the last one emitted.
+// Evaluations that fail transiently are skipped so that an API outage does not
+// look like a change. results is closed once ctx or stop is done.
+func watchFlag(ctx context.Context, stop <-chan struct{}, changed <-chan struct{}, evaluate func() FlagResult, results chan<- FlagResult) {
+	defer close(results)
+
+	var last interface{}
+	emitted := false
+	for {
+		result := evaluate()
+		if ctx.Err() != nil {
+			return
+		}
+
+		transient := result.Error != nil && result.ErrorCode != ErrorCodeFlagNotFound
+		if !emitted || (!transient && !reflect.DeepEqual(result.Value, last)) {
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			}
+			last, emitted = result.Value, true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package variably
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// websocketPath is the WebSocket endpoint for flag changes
+const websocketPath = "/api/v1/sdk/ws"
+
+// websocketGUID is appended to the handshake key to compute the accept header (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxWebSocketMessage bounds the size of a single message
+const maxWebSocketMessage = 1 << 20
+
+// errWebSocketMessageTooLarge is returned for messages over maxWebSocketMessage
+var errWebSocketMessageTooLarge = errors.New("WebSocket message too large")
+
+// WebSocket frame opcodes
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+// wsMessage is a flag change message sent over the WebSocket
+type wsMessage struct {
+	ID    string          `json:"id,omitempty"`
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// wsConn is a client WebSocket connection carrying flag change messages. It
+// answers pings, sends its own every heartbeat interval, and closes itself if
+// nothing has been received for two intervals.
+type wsConn struct {
+	conn   io.ReadWriteCloser
+	reader *bufio.Reader
+	logger Logger
+
+	writeMutex sync.Mutex
+	lastRead   int64 // unix nanoseconds
+
+	// Fragments of the message being received
+	message    []byte
+	fragmented bool
+
+	closeOnce sync.Once
+	done      chan struct{}
+	cancel    context.CancelFunc
+}
+
+// openWebSocket connects to the flag change WebSocket on the preferred healthy
+// endpoint, resuming after lastEventID if set
+func (c *HTTPClient) openWebSocket(ctx context.Context, lastEventID string, heartbeat time.Duration) (*wsConn, error) {
+	client := &http.Client{Transport: upgradeTransport(c.client.Transport)}
+	apiKey, _ := c.keys()
+
+	var lastErr error
+	for _, endpoint := range c.endpoints.candidates() {
+		url := endpoint + websocketPath
+
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, NewNetworkError("Failed to create handshake key", 0, url, err)
+		}
+		key := base64.StdEncoding.EncodeToString(nonce)
+
+		// The connection lives until closed, so only the handshake is bounded by the timeout
+		connCtx, cancel := context.WithCancel(ctx)
+		req, err := http.NewRequestWithContext(connCtx, "GET", url, nil)
+		if err != nil {
+			cancel()
+			return nil, NewNetworkError("Failed to create request", 0, url, err)
+		}
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", key)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		c.setRequestHeaders(req, apiKey, nil)
+
+		c.logger.Debug("Opening flag WebSocket", "endpoint", endpoint, "last_event_id", lastEventID)
+
+		handshakeTimer := time.AfterFunc(c.timeout, cancel)
+		resp, err := client.Do(req)
+		if !handshakeTimer.Stop() || err != nil {
+			cancel()
+			if err == nil {
+				resp.Body.Close()
+				err = context.DeadlineExceeded
+			}
+			lastErr = NewNetworkError("WebSocket connection failed", 0, url, err)
+			if ctx.Err() != nil {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			cancel()
+			lastErr = c.handleHTTPError(resp.StatusCode, resp.Header, body, url)
+			if resp.StatusCode < 400 {
+				lastErr = NewNetworkError(fmt.Sprintf("WebSocket upgrade refused with HTTP %d", resp.StatusCode), resp.StatusCode, url, nil)
+			}
+			if !isEndpointFailure(lastErr) {
+				return nil, lastErr
+			}
+			continue
+		}
+
+		rwc, ok := resp.Body.(io.ReadWriteCloser)
+		if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+			resp.Body.Close()
+			cancel()
+			return nil, NewNetworkError("Invalid WebSocket handshake response", resp.StatusCode, url, nil)
+		}
+
+		conn := &wsConn{
+			conn:     rwc,
+			reader:   bufio.NewReader(rwc),
+			logger:   c.logger,
+			lastRead: time.Now().UnixNano(),
+			done:     make(chan struct{}),
+			cancel:   cancel,
+		}
+		go conn.keepAlive(connCtx, heartbeat)
+		return conn, nil
+	}
+
+	return nil, lastErr
+}
+
+// upgradeTransport returns a transport that can upgrade connections. HTTP/2
+// does not support the Upgrade header, so the default transport is limited to HTTP/1.1.
+func upgradeTransport(rt http.RoundTripper) http.RoundTripper {
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+
+	transport = transport.Clone()
+	transport.ForceAttemptHTTP2 = false
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+	return transport
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value for a handshake key
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Next returns the next flag change message, answering pings along the way
+func (w *wsConn) Next() (streamEvent, error) {
+	for {
+		fin, opcode, payload, err := w.readFrame()
+		if err != nil {
+			return streamEvent{}, err
+		}
+		atomic.StoreInt64(&w.lastRead, time.Now().UnixNano())
+
+		switch opcode {
+		case wsOpPing:
+			if err := w.writeFrame(wsOpPong, payload); err != nil {
+				return streamEvent{}, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			// Echo the status code to complete the closing handshake
+			if len(payload) >= 2 {
+				payload = payload[:2]
+			}
+			w.writeFrame(wsOpClose, payload)
+			return streamEvent{}, errors.New("WebSocket closed by server")
+		case wsOpText, wsOpBinary:
+			if w.fragmented {
+				return streamEvent{}, errors.New("WebSocket message started before previous message finished")
+			}
+			if len(payload) > maxWebSocketMessage {
+				return streamEvent{}, errWebSocketMessageTooLarge
+			}
+			w.message = append(w.message[:0], payload...)
+			w.fragmented = !fin
+		case wsOpContinuation:
+			if !w.fragmented {
+				return streamEvent{}, errors.New("unexpected WebSocket continuation frame")
+			}
+			// Check before appending so fragments cannot grow the buffer without bound
+			if len(w.message)+len(payload) > maxWebSocketMessage {
+				return streamEvent{}, errWebSocketMessageTooLarge
+			}
+			w.message = append(w.message, payload...)
+			w.fragmented = !fin
+		default:
+			return streamEvent{}, fmt.Errorf("unknown WebSocket opcode %d", opcode)
+		}
+
+		if w.fragmented {
+			continue
+		}
+
+		var message wsMessage
+		if err := json.Unmarshal(w.message, &message); err != nil || message.Data == nil {
+			// Let the dispatcher report it as malformed
+			return streamEvent{Event: "message", Data: string(w.message)}, nil
+		}
+		if message.Event == "" {
+			message.Event = "message"
+		}
+		return streamEvent{ID: message.ID, Event: message.Event, Data: string(message.Data)}, nil
+	}
+}
+
+// RetryDelay returns zero; the WebSocket protocol has no reconnection hint
+func (w *wsConn) RetryDelay() time.Duration {
+	return 0
+}
+
+// Close closes the connection
+func (w *wsConn) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		err = w.conn.Close()
+		w.cancel()
+	})
+	return err
+}
+
+// keepAlive pings the server every interval and closes the connection if
+// nothing has been received for two intervals
+func (w *wsConn) keepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&w.lastRead)))
+			if idle > 2*interval {
+				w.logger.Warn("WebSocket connection is dead, closing", "idle", idle)
+				w.Close()
+				return
+			}
+			if err := w.writeFrame(wsOpPing, nil); err != nil {
+				w.Close()
+				return
+			}
+		case <-ctx.Done():
+			w.Close()
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// readFrame reads a single frame, unmasking its payload if needed
+func (w *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(w.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(w.reader, extended[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > maxWebSocketMessage {
+		return false, 0, nil, errors.New("WebSocket frame too large")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(w.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(w.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single final frame. Client frames are always masked.
+func (w *wsConn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	w.writeMutex.Lock()
+	defer w.writeMutex.Unlock()
+	_, err := w.conn.Write(frame)
+	return err
+}